@@ -0,0 +1,355 @@
+// Package client implements the client side of the Git LFS SSH transfer
+// protocol, as served by git-lfs-transfer.
+package client
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// StatusError is returned for responses with an unexpected status.
+type StatusError struct {
+	Status   int
+	Messages []string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("status %d", e.Status)
+	}
+	return fmt.Sprintf("status %d: %s", e.Status, strings.Join(e.Messages, ", "))
+}
+
+// Object is an object of a batch request.
+type Object struct {
+	Oid  string
+	Size int64
+}
+
+// BatchItem is the server's answer for an object of a batch request.
+type BatchItem struct {
+	Object
+	// Action is "upload", "download" or "noop".
+	Action string
+	// Offset is where an interrupted upload can be resumed.
+	Offset int64
+}
+
+// Lock is a lock as reported by the server.
+type Lock struct {
+	ID       string
+	Path     string
+	LockedAt string
+	Owner    string
+	// Ours is set in listings for locks owned by the user.
+	Ours bool
+}
+
+// ListOptions filters and pages the locks returned by ListLocks.
+type ListOptions struct {
+	Path    string
+	ID      string
+	Owner   string
+	Refspec string
+	Cursor  string
+	Limit   int
+}
+
+// Client runs commands in a session of the transfer protocol. Most errors
+// end the session on the server side, so a Client should not be used after
+// a command failed.
+type Client struct {
+	c *transfer.PktlineChannel
+	// Capabilities are those advertised by the server.
+	Capabilities []string
+}
+
+// New returns a client for a session reading responses from r and writing
+// requests to w, such as the stdout and stdin of an SSH session running
+// git-lfs-transfer. Negotiate must be called before any other command.
+func New(r io.Reader, w io.Writer) *Client {
+	return &Client{c: transfer.NewPktlineChannel(r, w)}
+}
+
+// Negotiate reads the capabilities of the server and selects version 1 of
+// the protocol.
+func (cl *Client) Negotiate() error {
+	caps, _, _, err := cl.c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	cl.Capabilities = caps
+	supported := false
+	for _, capability := range caps {
+		if capability == "version=1" {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("server does not support version 1")
+	}
+	_, _, err = cl.command([]string{"version 1"}, nil, 200)
+	return err
+}
+
+// Quit ends the session.
+func (cl *Client) Quit() error {
+	_, _, err := cl.command([]string{"quit"}, nil, 200)
+	return err
+}
+
+// Batch asks the server what to do with objects.
+func (cl *Client) Batch(objects []Object) ([]BatchItem, error) {
+	lines := make([]string, 0, len(objects))
+	for _, object := range objects {
+		lines = append(lines, fmt.Sprintf("%s %d", object.Oid, object.Size))
+	}
+	_, lines, err := cl.command([]string{"batch", "hash-algo=sha256"}, lines, 200)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]BatchItem, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Split(line, " ")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid batch response %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch response %q", line)
+		}
+		item := BatchItem{Object: Object{Oid: fields[0], Size: size}, Action: fields[2]}
+		for _, field := range fields[3:] {
+			if strings.HasPrefix(field, "offset=") {
+				item.Offset, err = strconv.ParseInt(field[7:], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid batch response %q", line)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// PutObject uploads the object oid of size bytes, reading its content from
+// r. A non-zero offset resumes an interrupted upload, r then starts at the
+// offset.
+func (cl *Client) PutObject(oid string, size int64, offset int64, r io.Reader) error {
+	args := []string{"put-object " + oid, fmt.Sprintf("size=%d", size)}
+	if offset > 0 {
+		args = append(args, fmt.Sprintf("offset=%d", offset))
+	}
+	if err := cl.c.SendMessageData(args, r); err != nil {
+		return err
+	}
+	_, _, err := cl.response(200)
+	return err
+}
+
+// VerifyObject checks that the server has the object oid of size bytes.
+func (cl *Client) VerifyObject(oid string, size int64) error {
+	_, _, err := cl.command([]string{"verify-object " + oid, fmt.Sprintf("size=%d", size)}, nil, 200)
+	return err
+}
+
+// GetObject downloads the object oid from offset on and writes it to w. It
+// returns the number of bytes written.
+func (cl *Client) GetObject(oid string, offset int64, w io.Writer) (int64, error) {
+	args := []string{"get-object " + oid}
+	if offset > 0 {
+		args = append(args, fmt.Sprintf("offset=%d", offset))
+	}
+	if err := cl.c.SendMessage(args, nil); err != nil {
+		return 0, err
+	}
+	args, data, err := cl.c.ReadMessageData()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStatus(args, data, 200); err != nil {
+		return 0, err
+	}
+	size := int64(-1)
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "size=") {
+			size, err = strconv.ParseInt(arg[5:], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", arg[5:])
+			}
+		}
+	}
+	if data == nil {
+		if size > 0 {
+			return 0, fmt.Errorf("missing object data")
+		}
+		return 0, nil
+	}
+	n, err := io.Copy(w, data)
+	if err != nil {
+		return n, err
+	}
+	if size >= 0 && n != size {
+		return n, fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	return n, nil
+}
+
+// Lock locks path, on refname only if it is not empty and the server scopes
+// locks to refs. If the path is already locked, the existing lock is
+// returned together with the error.
+func (cl *Client) Lock(path string, refname string) (*Lock, error) {
+	args := []string{"lock", "path=" + path}
+	if refname != "" {
+		args = append(args, "refname="+refname)
+	}
+	args, lines, err := cl.command(args, nil, 201)
+	if err, ok := err.(*StatusError); ok && err.Status == 409 {
+		return parseLock(lines), err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseLock(args[1:]), nil
+}
+
+// Unlock removes the lock id. Removing the lock of another user requires
+// force.
+func (cl *Client) Unlock(id string, force bool) (*Lock, error) {
+	args := []string{"unlock " + id}
+	if force {
+		args = append(args, "force=true")
+	}
+	args, _, err := cl.command(args, nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	return parseLock(args[1:]), nil
+}
+
+// ListLocks returns the locks matching opts. If there are more than
+// opts.Limit locks, the cursor to list the next ones from is returned too.
+func (cl *Client) ListLocks(opts ListOptions) ([]*Lock, string, error) {
+	args := []string{"list-lock"}
+	for _, arg := range []struct{ key, value string }{
+		{"path", opts.Path},
+		{"id", opts.ID},
+		{"owner", opts.Owner},
+		{"refspec", opts.Refspec},
+		{"cursor", opts.Cursor},
+	} {
+		if arg.value != "" {
+			args = append(args, arg.key+"="+arg.value)
+		}
+	}
+	if opts.Limit > 0 {
+		args = append(args, fmt.Sprintf("limit=%d", opts.Limit))
+	}
+	args, lines, err := cl.command(args, nil, 202)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "next-cursor=") {
+			next = arg[12:]
+		}
+	}
+	var locks []*Lock
+	byID := map[string]*Lock{}
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, "", fmt.Errorf("invalid lock list line %q", line)
+		}
+		if fields[0] == "lock" {
+			lock := &Lock{ID: fields[1]}
+			locks = append(locks, lock)
+			byID[lock.ID] = lock
+			continue
+		}
+		lock, ok := byID[fields[1]]
+		if !ok || len(fields) < 3 {
+			return nil, "", fmt.Errorf("invalid lock list line %q", line)
+		}
+		switch fields[0] {
+		case "path":
+			lock.Path = fields[2]
+		case "locked-at":
+			lock.LockedAt = fields[2]
+		case "ownername":
+			lock.Owner = fields[2]
+		case "owner":
+			lock.Ours = fields[2] == "ours"
+		}
+	}
+	return locks, next, nil
+}
+
+// command sends a request and reads the response, which must have status.
+func (cl *Client) command(args []string, lines []string, status int) ([]string, []string, error) {
+	if err := cl.c.SendMessage(args, lines); err != nil {
+		return nil, nil, err
+	}
+	return cl.response(status)
+}
+
+func (cl *Client) response(status int) ([]string, []string, error) {
+	args, lines, _, err := cl.c.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkStatus(args, nil, status); err != nil {
+		if err, ok := err.(*StatusError); ok {
+			err.Messages = lines
+		}
+		return args, lines, err
+	}
+	return args, lines, nil
+}
+
+// checkStatus returns an error unless args start with status. The messages
+// of an error response are read from data if it is not nil.
+func checkStatus(args []string, data io.Reader, status int) error {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "status ") {
+		return fmt.Errorf("invalid response %q", args)
+	}
+	code, err := strconv.Atoi(args[0][7:])
+	if err != nil {
+		return fmt.Errorf("invalid response %q", args)
+	}
+	if code == status {
+		return nil
+	}
+	statusErr := &StatusError{Status: code}
+	if data != nil {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		statusErr.Messages = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	return statusErr
+}
+
+func parseLock(args []string) *Lock {
+	lock := &Lock{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "id="):
+			lock.ID = arg[3:]
+		case strings.HasPrefix(arg, "path="):
+			lock.Path = arg[5:]
+		case strings.HasPrefix(arg, "locked-at="):
+			lock.LockedAt = arg[10:]
+		case strings.HasPrefix(arg, "ownername="):
+			lock.Owner = arg[10:]
+		}
+	}
+	return lock
+}
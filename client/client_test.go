@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func newTestServer(t *testing.T, user string) *transfer.Server {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo.git")
+	for _, d := range []string{"objects", "refs", "lfs/objects", "lfs/tmp", "lfs/locks"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte("[core]\n\tbare = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := transfer.OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transfer.NewServer(repo, transfer.NewLocalStorage(repo.LFSDir), transfer.NewFileLockStore(repo.LFSDir), &transfer.Identity{User: user})
+}
+
+// connect runs a session of s in the background and returns a client for it.
+func connect(t *testing.T, s *transfer.Server, operation string) *Client {
+	t.Helper()
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		err := s.Serve(struct {
+			io.Reader
+			io.Writer
+		}{sr, sw}, operation)
+		sw.CloseWithError(err)
+	}()
+	t.Cleanup(func() { cw.Close() })
+
+	cl := New(cr, cw)
+	if err := cl.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+func TestClientObjects(t *testing.T) {
+	s := newTestServer(t, "alice")
+	content := "abc123"
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	objects := []Object{{Oid: oid, Size: int64(len(content))}}
+
+	cl := connect(t, s, "upload")
+	items, err := cl.Batch(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Oid != oid || items[0].Action != "upload" {
+		t.Fatalf("unexpected batch response %+v", items)
+	}
+	if err := cl.PutObject(oid, int64(len(content)), 0, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.VerifyObject(oid, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	cl = connect(t, s, "download")
+	items, err = cl.Batch(append(objects, Object{Oid: strings.Repeat("0", 64), Size: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Action != "download" || items[1].Action != "noop" {
+		t.Fatalf("unexpected batch response %+v", items)
+	}
+	buf := new(bytes.Buffer)
+	if n, err := cl.GetObject(oid, 3, buf); err != nil || n != 3 || buf.String() != "123" {
+		t.Fatalf("unexpected download %q, %d, %v", buf, n, err)
+	}
+	_, err = cl.GetObject(strings.Repeat("0", 64), 0, buf)
+	if err, ok := err.(*StatusError); !ok || err.Status != 400 || err.Messages[0] != "not found" {
+		t.Fatalf("unexpected error for missing object: %v", err)
+	}
+	if err := cl.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientLocks(t *testing.T) {
+	s := newTestServer(t, "alice")
+	cl := connect(t, s, "upload")
+	lock, err := cl.Lock("a.bin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Path != "a.bin" || lock.Owner != "alice" || lock.ID == "" || lock.LockedAt == "" {
+		t.Fatalf("unexpected lock %+v", lock)
+	}
+	if _, err := cl.Lock("b.bin", ""); err != nil {
+		t.Fatal(err)
+	}
+	locks, next, err := cl.ListLocks(ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || next == "" || !locks[0].Ours || locks[0].Owner != "alice" {
+		t.Fatalf("unexpected locks %+v, next %q", locks, next)
+	}
+	locks, next, err = cl.ListLocks(ListOptions{Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || next != "" {
+		t.Fatalf("unexpected locks %+v, next %q", locks, next)
+	}
+	if err := cl.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Identity = &transfer.Identity{User: "bob"}
+	s.Permissions = transfer.PermAll
+	cl = connect(t, s, "upload")
+	existing, err := cl.Lock("a.bin", "")
+	if err, ok := err.(*StatusError); !ok || err.Status != 409 {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if existing.ID != lock.ID || existing.Owner != "alice" {
+		t.Fatalf("unexpected conflicting lock %+v", existing)
+	}
+
+	cl = connect(t, s, "upload")
+	locks, _, err = cl.ListLocks(ListOptions{Path: "a.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Ours {
+		t.Fatalf("unexpected locks %+v", locks)
+	}
+	unlocked, err := cl.Unlock(lock.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.ID != lock.ID || unlocked.Path != "a.bin" {
+		t.Fatalf("unexpected unlocked lock %+v", unlocked)
+	}
+	if err := cl.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
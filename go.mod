@@ -5,14 +5,27 @@ go 1.20
 require (
 	github.com/git-lfs/git-lfs/v3 v3.3.0
 	github.com/git-lfs/pktline v0.0.0-20230103162542-ca444d533ef1
+	github.com/git-lfs/wildmatch/v2 v2.0.1
+	modernc.org/sqlite v1.33.1
 )
 
 require (
 	github.com/avast/retry-go v2.4.2+incompatible // indirect
-	github.com/git-lfs/wildmatch/v2 v2.0.1 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/leonelquinteros/gotext v1.5.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/pkg/errors v0.0.0-20170505043639-c605e284fe17 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/rubyist/tracerx v0.0.0-20170927163412-787959303086 // indirect
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.3.7 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
 )
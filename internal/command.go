@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ParseCommand splits a command line as received in SSH_ORIGINAL_COMMAND
+// into arguments shaped like os.Args. Only quoting is interpreted; anything
+// that would need a shell to evaluate it is rejected. The repository path is
+// the client's, see clientRepoPath.
+func ParseCommand(s string) ([]string, error) {
+	words, err := splitShellWords(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) != 3 {
+		return nil, fmt.Errorf("expected 2 arguments, got %d", len(words)-1)
+	}
+	if filepath.Base(words[0]) != "git-lfs-transfer" {
+		return nil, fmt.Errorf("unknown command %q", words[0])
+	}
+	words[1] = clientRepoPath(words[1])
+	if words[1] == "" {
+		return nil, fmt.Errorf("empty repository path")
+	}
+	if words[2] != "upload" && words[2] != "download" {
+		return nil, fmt.Errorf("unknown operation")
+	}
+	return words, nil
+}
+
+// clientRepoPath returns the repository path a client sent, which is the
+// path of an ssh:// URL and so starts with a slash, relative to the server
+// root or the working directory.
+func clientRepoPath(path string) string {
+	return strings.TrimLeft(path, "/")
+}
+
+func splitShellWords(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case ch == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case ch == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				} else if s[i] == '$' || s[i] == '`' {
+					return nil, fmt.Errorf("unsupported character %q in command", s[i])
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case ch == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("trailing backslash in command")
+			}
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case strings.IndexByte("\n;&|<>()$`*?[]{}~#!", ch) >= 0:
+			return nil, fmt.Errorf("unsupported character %q in command", ch)
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected []string
+	}{
+		{"git-lfs-transfer 'repo.git' upload", []string{"git-lfs-transfer", "repo.git", "upload"}},
+		{"git-lfs-transfer '/srv/git/my repo.git' download", []string{"git-lfs-transfer", "srv/git/my repo.git", "download"}},
+		{`git-lfs-transfer "it's.git" upload`, []string{"git-lfs-transfer", "it's.git", "upload"}},
+		{`git-lfs-transfer it\'s.git  download`, []string{"git-lfs-transfer", "it's.git", "download"}},
+		{"/usr/bin/git-lfs-transfer repo.git upload", []string{"/usr/bin/git-lfs-transfer", "repo.git", "upload"}},
+		{"git-lfs-transfer repo.git", nil},
+		{"git-lfs-transfer repo.git upload extra", nil},
+		{"git-lfs-transfer repo.git delete", nil},
+		{"git-upload-pack 'repo.git' upload", nil},
+		{"git-lfs-transfer 'repo.git upload", nil},
+		{"git-lfs-transfer repo.git upload; rm -rf /", nil},
+		{"git-lfs-transfer $(id).git upload", nil},
+		{"git-lfs-transfer \"$HOME\" upload", nil},
+		{"git-lfs-transfer `id` upload", nil},
+		{"git-lfs-transfer '' upload", nil},
+		{"git-lfs-transfer / upload", nil},
+	}
+	for _, tt := range tests {
+		args, err := ParseCommand(tt.cmd)
+		if tt.expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tt.cmd, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("%q: got %q, want %q", tt.cmd, args, tt.expected)
+		}
+	}
+}
+
+func TestForcedCommandRoot(t *testing.T) {
+	root := t.TempDir()
+	initGitDir(t, filepath.Join(root, "foo.git"), "[core]\n\tbare = true\n")
+	t.Setenv("GIT_LFS_TRANSFER_ROOT", root)
+
+	forced, err := ParseCommand("git-lfs-transfer '/foo.git' download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		forced,
+		{"git-lfs-transfer", "'/foo.git'", "download"},
+		{"git-lfs-transfer", "foo.git", "download"},
+	} {
+		result, err := runSession(t, args[1], args[2])
+		if err != nil || !strings.HasSuffix(result, "< status 200\n< flush\n") {
+			t.Errorf("%q: unexpected response: %v\n%s", args, err, result)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "foo.git", "lfs")); err != nil {
+		t.Errorf("repository was not served: %s", err)
+	}
+}
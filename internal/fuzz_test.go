@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func FuzzTransfer(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		sc, err := parseScenario(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, sess := range sc.sessions {
+			f.Add(sess.input.Bytes(), sess.operation == "upload")
+		}
+	}
+	f.Setenv("LFS_USER", "alice")
+
+	f.Fuzz(func(t *testing.T, input []byte, upload bool) {
+		root := t.TempDir()
+		dir := filepath.Join(root, "repo")
+		initGitDir(t, filepath.Join(dir, ".git"), "[core]\n\tbare = false\n")
+		operation := "download"
+		if upload {
+			operation = "upload"
+		}
+		TransferWithOptions(bytes.NewReader(input), io.Discard, []string{"", dir, operation}, Options{UserEnv: "LFS_USER"})
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("files written outside of the repository: %v", entries)
+		}
+		lfsPath := filepath.Join(dir, ".git", "lfs")
+		if entries, _ := os.ReadDir(filepath.Join(lfsPath, "tmp")); len(entries) != 0 {
+			t.Fatalf("temporary files left behind: %v", entries)
+		}
+		for _, d := range []string{"incomplete", "locks"} {
+			entries, _ := os.ReadDir(filepath.Join(lfsPath, d))
+			for _, entry := range entries {
+				if !transfer.ValidOid(entry.Name()) {
+					t.Fatalf("unexpected file %s in %s", entry.Name(), d)
+				}
+			}
+		}
+		filepath.Walk(filepath.Join(lfsPath, "objects"), func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(b)
+			if hex.EncodeToString(sum[:]) != fi.Name() {
+				t.Fatalf("object %s does not match its content", fi.Name())
+			}
+			return nil
+		})
+	})
+}
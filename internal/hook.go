@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+var errLockedPaths = errors.New("push changes files locked by other users")
+
+// PreReceive implements the pre-receive hook of the repository at path. It
+// reads the ref updates from r, one "<old> <new> <refname>" line each, and
+// refuses the push with errLockedPaths if any of the new commits change a
+// path locked by a user other than the one pushing. The refused paths are
+// listed on w.
+//
+// An empty path is the repository Git runs the hook in, named by GIT_DIR or
+// else the working directory. The hook is the only place GIT_DIR is
+// honoured; transfers serve the repository the client names.
+func PreReceive(r io.Reader, w io.Writer, path string, opts Options) error {
+	if path == "" {
+		path = os.Getenv("GIT_DIR")
+	}
+	if path == "" {
+		path = "."
+	}
+	repo, err := transfer.OpenRepository(path)
+	if err != nil {
+		return err
+	}
+	ttl, err := lockTTL(opts, repo)
+	if err != nil {
+		return err
+	}
+	refLocks := opts.RefLocks || repo.ConfigBool("lfs-transfer.reflocks", false)
+	id, err := currentIdentity(opts)
+	if err != nil {
+		return err
+	}
+
+	store, err := openLockStore(opts, repo)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	locks := map[string][]*transfer.Lock{}
+	now := time.Now()
+	err = store.List(transfer.LockFilter{}, func(lock *transfer.Lock) error {
+		if lock.Owner != id.User && !lock.Expired(now, ttl) {
+			locks[lock.Path] = append(locks[lock.Path], lock)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var refused []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid ref update %q", scanner.Text())
+		}
+		oldOid, newOid, refname := fields[0], fields[1], fields[2]
+		if len(locks) == 0 || strings.Trim(newOid, "0") == "" {
+			// nothing locked, or the ref is deleted
+			continue
+		}
+		paths, err := changedPaths(repo.GitDir, oldOid, newOid)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			for _, lock := range locks[p] {
+				if lock.Covers(refname, refLocks) {
+					refused = append(refused, fmt.Sprintf("%s: %s is locked by %s", refname, p, lock.Owner))
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, line := range refused {
+		fmt.Fprintln(w, line)
+	}
+	if len(refused) > 0 {
+		return errLockedPaths
+	}
+	return nil
+}
+
+// changedPaths returns the paths changed by the commits that updating a ref
+// from oldOid to newOid adds to it, including those other refs already have.
+// A new ref is only checked for the commits no other ref has. Merges only
+// count the changes they make themselves, not those of the merged commits.
+func changedPaths(gitDir string, oldOid string, newOid string) ([]string, error) {
+	args := []string{"--git-dir", gitDir, "log", "-z", "--format=", "--name-only", "--no-renames", "-c", newOid, "--not"}
+	if strings.Trim(oldOid, "0") == "" {
+		args = append(args, "--all")
+	} else {
+		args = append(args, oldOid)
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var paths []string
+	seen := map[string]bool{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		p = strings.Trim(p, "\n")
+		if p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func commitFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", "change "+name)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+func TestPreReceive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "Test")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "test@example.com")
+	}
+	t.Setenv("LFS_USER", "bob")
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "checkout", "-q", "-b", "main")
+	commitFile(t, dir, "b.bin", "b")
+	base := commitFile(t, dir, "a.bin", "a")
+	present := commitFile(t, dir, "a.bin", "a1")
+	// commits no ref has are not in the repository yet, as if they were pushed
+	runGit(t, dir, "checkout", "-q", base)
+	changeA := commitFile(t, dir, "a.bin", "a2")
+	runGit(t, dir, "checkout", "-q", base)
+	changeB := commitFile(t, dir, "b.bin", "b2")
+	newBranch := commitFile(t, dir, "a.bin", "a3")
+	runGit(t, dir, "checkout", "-q", present)
+	runGit(t, dir, "merge", "-q", "--no-edit", changeB)
+	merge := runGit(t, dir, "rev-parse", "HEAD")
+	// dev already has the change main is fast-forwarded to
+	runGit(t, dir, "checkout", "-q", "-b", "dev", present)
+	changeC := commitFile(t, dir, "c.bin", "c")
+	runGit(t, dir, "checkout", "-q", "main")
+
+	lfsPath := filepath.Join(dir, ".git", "lfs")
+	for _, d := range []string{"locks", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(lfsPath, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locks := transfer.NewFileLockStore(lfsPath)
+	for _, lock := range []*transfer.Lock{
+		{ID: transfer.LockID("a.bin", "", true), Path: "a.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"},
+		{ID: transfer.LockID("b.bin", "refs/heads/dev", true), Path: "b.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z", Refname: "refs/heads/dev"},
+		{ID: transfer.LockID("c.bin", "refs/heads/main", true), Path: "c.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z", Refname: "refs/heads/main"},
+	} {
+		if err := locks.Create(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zero := strings.Repeat("0", 40)
+	tests := []struct {
+		user     string
+		refLocks bool
+		updates  string
+		refused  string
+	}{
+		{"bob", false, base + " " + changeA + " refs/heads/main\n", "refs/heads/main: a.bin is locked by alice\n"},
+		{"alice", false, base + " " + changeA + " refs/heads/main\n", ""},
+		{"bob", false, base + " " + changeB + " refs/heads/main\n", "refs/heads/main: b.bin is locked by alice\n"},
+		{"bob", true, base + " " + changeB + " refs/heads/main\n", ""},
+		{"bob", true, base + " " + changeB + " refs/heads/dev\n", "refs/heads/dev: b.bin is locked by alice\n"},
+		{"bob", true, base + " " + changeA + " refs/heads/main\n", "refs/heads/main: a.bin is locked by alice\n"},
+		{"bob", false, zero + " " + newBranch + " refs/heads/topic\n", "refs/heads/topic: a.bin is locked by alice\nrefs/heads/topic: b.bin is locked by alice\n"},
+		{"bob", false, changeA + " " + zero + " refs/heads/main\n", ""},
+		// the commits are checked even though main already has them
+		{"bob", false, base + " " + present + " refs/heads/main\n", "refs/heads/main: a.bin is locked by alice\n"},
+		{"bob", false, present + " " + merge + " refs/heads/main\n", "refs/heads/main: b.bin is locked by alice\n"},
+		{"bob", true, present + " " + changeC + " refs/heads/main\n", "refs/heads/main: c.bin is locked by alice\n"},
+		{"bob", false, present + " " + changeC + " refs/heads/main\n", "refs/heads/main: c.bin is locked by alice\n"},
+		{"bob", true, present + " " + changeC + " refs/heads/dev\n", ""},
+	}
+	// git runs the hook with GIT_DIR set
+	t.Setenv("GIT_DIR", filepath.Join(dir, ".git"))
+	for _, tt := range tests {
+		t.Setenv("LFS_USER", tt.user)
+		w := new(bytes.Buffer)
+		err := PreReceive(strings.NewReader(tt.updates), w, "", Options{UserEnv: "LFS_USER", RefLocks: tt.refLocks})
+		if tt.refused == "" && err != nil {
+			t.Errorf("%s pushing %q: unexpected error %v: %s", tt.user, tt.updates, err, w)
+		}
+		if tt.refused != "" && (err != errLockedPaths || w.String() != tt.refused) {
+			t.Errorf("%s pushing %q: got %v\n%s\nwant refused:\n%s", tt.user, tt.updates, err, w, tt.refused)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// currentIdentity returns who the session acts for. By default that is the
+// Unix user running the server, but hosts where everybody logs in with a
+// shared account can name the environment variable holding the actual user
+// in opts.UserEnv, typically set by the forced command of each key.
+func currentIdentity(opts Options) (*transfer.Identity, error) {
+	if opts.UserEnv != "" {
+		name := os.Getenv(opts.UserEnv)
+		if name == "" {
+			return nil, fmt.Errorf("no user name in %s", opts.UserEnv)
+		}
+		return &transfer.Identity{User: name, Key: opts.Key, Client: sshClient()}, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	id := &transfer.Identity{
+		User:   u.Username,
+		Key:    opts.Key,
+		Client: sshClient(),
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		id.Groups = append(id.Groups, g.Name)
+	}
+	return id, nil
+}
+
+// sshClient returns the client address from SSH_CLIENT, which sshd sets to
+// "<address> <port> <server port>".
+func sshClient() string {
+	if fields := strings.Fields(os.Getenv("SSH_CLIENT")); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func TestMigrateGobLock(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	t.Setenv("LFS_USER", "jan")
+
+	// create the lfs directories
+	runSession(t, dir, "upload")
+
+	id := "c7b8de23fd238fe5e16f6f03b844022f9f72fd168a0704d82d58f19cf72b7aa3"
+	lockpath := filepath.Join(dir, ".git", "lfs", "locks", id)
+	b := new(bytes.Buffer)
+	err := gob.NewEncoder(b).Encode(map[string]string{
+		"path":      "test.zip",
+		"locked-at": "2023-01-02T03:04:05Z",
+		"ownername": "jan",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockpath, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "< version=1\n< locking\n< flush\n< status 200\n< flush\n" +
+		"< status 202\n< delim\n" +
+		"< lock " + id + "\n" +
+		"< path " + id + " test.zip\n" +
+		"< locked-at " + id + " 2023-01-02T03:04:05Z\n" +
+		"< ownername " + id + " jan\n" +
+		"< owner " + id + " ours\n" +
+		"< flush\n"
+	if result, _ := runSession(t, dir, "upload", "list-lock", "flush"); result != expected {
+		t.Errorf("unexpected response\ngot:\n%s\nwant:\n%s", result, expected)
+	}
+
+	data, err := os.ReadFile(lockpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock transfer.Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		t.Fatalf("lock was not migrated: %s", err)
+	}
+	expectedLock := transfer.Lock{Version: 1, ID: id, Path: "test.zip", Owner: "jan", LockedAt: "2023-01-02T03:04:05Z"}
+	if lock != expectedLock {
+		t.Errorf("got %+v, want %+v", lock, expectedLock)
+	}
+}
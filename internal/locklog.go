@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// LockLogFilter selects events from the audit log. Empty fields match
+// everything; User matches both the user and the lock owner.
+type LockLogFilter struct {
+	Path  string
+	ID    string
+	User  string
+	Since time.Time
+}
+
+func (f LockLogFilter) match(event transfer.LockEvent) bool {
+	if f.Path != "" && event.Path != f.Path {
+		return false
+	}
+	if f.ID != "" && event.ID != f.ID {
+		return false
+	}
+	if f.User != "" && event.User != f.User && event.Owner != f.User {
+		return false
+	}
+	if !f.Since.IsZero() {
+		t, err := time.Parse(time.RFC3339, event.Time)
+		if err != nil || t.Before(f.Since) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadLockLog returns the events of the audit log of the repository at path
+// that match filter, oldest first.
+func ReadLockLog(path string, opts Options, filter LockLogFilter) ([]transfer.LockEvent, error) {
+	repo, err := openRepository(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(repo.LFSDir, "logs", "locks.log"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []transfer.LockEvent{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		var event transfer.LockEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return events, fmt.Errorf("invalid entry on line %d of locks.log: %s", n, err)
+		}
+		if filter.match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
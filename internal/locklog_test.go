@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func TestLockLog(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	t.Setenv("SSH_CLIENT", "192.0.2.1 52000 22")
+	t.Setenv("GIT_LFS_TRANSFER_POLICY", writePolicy(t, "* ** read,write,lock\nuser:carol ** admin\n"))
+
+	id := "c7b8de23fd238fe5e16f6f03b844022f9f72fd168a0704d82d58f19cf72b7aa3"
+	sessions := []struct {
+		user    string
+		packets []string
+	}{
+		{"alice", []string{"lock", "path=test.zip", "flush"}},
+		{"alice", []string{"lock", "path=other.zip", "flush"}},
+		{"alice", []string{"unlock " + id, "flush"}},
+		{"bob", []string{"lock", "path=test.zip", "flush"}},
+		{"carol", []string{"unlock " + id, "force=true", "flush"}},
+	}
+	for _, sess := range sessions {
+		t.Setenv("LFS_USER", sess.user)
+		runSession(t, dir, "upload", sess.packets...)
+	}
+
+	events, err := ReadLockLog(dir, Options{}, LockLogFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []transfer.LockEvent{
+		{Action: "lock", ID: id, Path: "test.zip", Owner: "alice", User: "alice", Client: "192.0.2.1"},
+		{Action: "lock", Path: "other.zip", Owner: "alice", User: "alice", Client: "192.0.2.1"},
+		{Action: "unlock", ID: id, Path: "test.zip", Owner: "alice", User: "alice", Client: "192.0.2.1"},
+		{Action: "lock", ID: id, Path: "test.zip", Owner: "bob", User: "bob", Client: "192.0.2.1"},
+		{Action: "unlock", ID: id, Path: "test.zip", Owner: "bob", User: "carol", Force: true, Client: "192.0.2.1"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(expected), events)
+	}
+	for i, e := range events {
+		if _, err := time.Parse(time.RFC3339, e.Time); err != nil {
+			t.Errorf("event %d: invalid time %q", i, e.Time)
+		}
+		e.Time = ""
+		if expected[i].ID == "" {
+			e.ID = ""
+		}
+		if e != expected[i] {
+			t.Errorf("event %d: got %+v, want %+v", i, e, expected[i])
+		}
+	}
+
+	tests := []struct {
+		filter   LockLogFilter
+		expected int
+	}{
+		{LockLogFilter{Path: "test.zip"}, 4},
+		{LockLogFilter{ID: id}, 4},
+		{LockLogFilter{User: "bob"}, 2},
+		{LockLogFilter{User: "carol"}, 1},
+		{LockLogFilter{Since: time.Now().Add(time.Hour)}, 0},
+		{LockLogFilter{Since: time.Now().Add(-time.Hour), Path: "other.zip"}, 1},
+	}
+	for _, tt := range tests {
+		events, err := ReadLockLog(dir, Options{}, tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != tt.expected {
+			t.Errorf("%+v: got %d events, want %d", tt.filter, len(events), tt.expected)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// openLockStore returns the lock store of repo selected with
+// lfs-transfer.lockStore or opts.LockStore. Locks left in the file store are
+// moved into a newly selected store when it is opened.
+func openLockStore(opts Options, repo *transfer.Repository) (transfer.LockStore, error) {
+	kind := opts.LockStore
+	if v, ok := repo.Config("lfs-transfer.lockstore"); ok {
+		kind = v
+	}
+	files := transfer.NewFileLockStore(repo.LFSDir)
+	switch kind {
+	case "", "file":
+		return files, nil
+	case "sqlite":
+		store, err := transfer.OpenSQLiteLockStore(filepath.Join(repo.LFSDir, "locks.db"))
+		if err != nil {
+			return nil, err
+		}
+		if err := migrateLocks(files, store); err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown lock store %q", kind)
+}
+
+// migrateLocks moves all locks from one store to another. Locks that exist
+// in both are kept as they are in the destination. If a lock conflicts with
+// another lock in the destination, nothing is removed from the source.
+func migrateLocks(from transfer.LockStore, to transfer.LockStore) error {
+	var ids []string
+	err := from.List(transfer.LockFilter{}, func(lock *transfer.Lock) error {
+		err := to.Create(lock)
+		var conflict *transfer.LockConflictError
+		if errors.As(err, &conflict) && conflict.Lock.ID != lock.ID {
+			return fmt.Errorf("cannot migrate lock %s: it conflicts with lock %s on %s", lock.ID, conflict.Lock.ID, conflict.Lock.Path)
+		}
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		ids = append(ids, lock.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := from.Delete(id, nil); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func TestTransferSQLiteLocks(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	t.Setenv("LFS_USER", "jan")
+
+	// a lock taken with the file store is moved to the database
+	runSession(t, dir, "upload", "lock", "path=test.zip", "flush")
+
+	t.Setenv("GIT_LFS_TRANSFER_LOCK_STORE", "sqlite")
+	id := "c7b8de23fd238fe5e16f6f03b844022f9f72fd168a0704d82d58f19cf72b7aa3"
+	result, _ := runSession(t, dir, "upload", "list-lock", "owner=jan", "flush")
+	if !strings.Contains(result, "< lock "+id+"\n") {
+		t.Errorf("lock was not migrated:\n%s", result)
+	}
+	files, err := os.ReadDir(filepath.Join(dir, ".git", "lfs", "locks"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("lock files left after migration: %v (%v)", files, err)
+	}
+
+	result, _ = runSession(t, dir, "upload", "lock", "path=test.zip", "flush")
+	if !strings.Contains(result, "< status 409\n") {
+		t.Errorf("expected conflict:\n%s", result)
+	}
+
+	result, _ = runSession(t, dir, "upload", "unlock "+id, "flush")
+	if !strings.Contains(result, "< status 200\n< id="+id+"\n") {
+		t.Errorf("unlock failed:\n%s", result)
+	}
+
+	result, _ = runSession(t, dir, "upload", "list-lock", "flush")
+	if strings.Contains(result, "< lock ") {
+		t.Errorf("lock was not removed:\n%s", result)
+	}
+
+	t.Setenv("GIT_LFS_TRANSFER_LOCK_STORE", "nosql")
+	result, err = runSession(t, dir, "upload")
+	if err == nil || !strings.Contains(result, "< cannot open lock store\n") {
+		t.Errorf("unknown lock store was accepted: %v\n%s", err, result)
+	}
+}
+
+func TestMigrateLocksConflict(t *testing.T) {
+	lfsPath := filepath.Join(newTestRepo(t), ".git", "lfs")
+	for _, d := range []string{"locks", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(lfsPath, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := transfer.NewFileLockStore(lfsPath)
+	db, err := transfer.OpenSQLiteLockStore(filepath.Join(lfsPath, "locks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	same := &transfer.Lock{ID: transfer.LockID("a.bin", "", false), Path: "a.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"}
+	global := &transfer.Lock{ID: transfer.LockID("b.bin", "", false), Path: "b.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"}
+	dev := &transfer.Lock{ID: transfer.LockID("b.bin", "refs/heads/dev", true), Path: "b.bin", Owner: "bob", LockedAt: "2023-01-02T03:04:05Z", Refname: "refs/heads/dev"}
+	for _, lock := range []*transfer.Lock{same, global} {
+		if err := files.Create(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, lock := range []*transfer.Lock{same, dev} {
+		if err := db.Create(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateLocks(files, db); err == nil {
+		t.Errorf("conflicting lock was migrated")
+	}
+	for _, lock := range []*transfer.Lock{same, global} {
+		if _, err := files.Get(lock.ID); err != nil {
+			t.Errorf("lock on %s was removed: %s", lock.Path, err)
+		}
+	}
+
+	// once the conflict is gone, the lock already in the database is skipped
+	if _, err := db.Delete(dev.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLocks(files, db); err != nil {
+		t.Fatal(err)
+	}
+	for _, lock := range []*transfer.Lock{same, global} {
+		if _, err := db.Get(lock.ID); err != nil {
+			t.Errorf("lock on %s was not migrated: %s", lock.Path, err)
+		}
+		if _, err := files.Get(lock.ID); !os.IsNotExist(err) {
+			t.Errorf("lock on %s was left in the file store: %v", lock.Path, err)
+		}
+	}
+}
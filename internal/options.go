@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+type Options struct {
+	// Root restricts the repositories that can be served to those below it.
+	Root string
+	// Policy is the path of the access policy file, see Policy.
+	Policy string
+	// Key identifies the SSH key the session was authenticated with.
+	Key string
+	// UserEnv names the environment variable holding the user name, for
+	// hosts where all users share one account.
+	UserEnv string
+	// ReadOnly refuses everything that would modify the repository.
+	ReadOnly bool
+	// RefLocks scopes locks to the ref given by the client instead of
+	// locking a path on all refs.
+	RefLocks bool
+	// LockTTL is how long locks last, or zero for locks that never expire.
+	LockTTL time.Duration
+	// LockStore selects where locks are kept, "file" (the default) or
+	// "sqlite".
+	LockStore string
+	// S3 selects an S3-compatible bucket for objects if a bucket is set.
+	S3 S3Config
+	// Pool is a directory of objects shared between repositories.
+	Pool string
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+func OptionsFromEnv() Options {
+	return Options{
+		Root:      os.Getenv("GIT_LFS_TRANSFER_ROOT"),
+		Policy:    os.Getenv("GIT_LFS_TRANSFER_POLICY"),
+		Key:       os.Getenv("GIT_LFS_TRANSFER_KEY"),
+		UserEnv:   os.Getenv("GIT_LFS_TRANSFER_USER_ENV"),
+		ReadOnly:  envBool("GIT_LFS_TRANSFER_READONLY"),
+		RefLocks:  envBool("GIT_LFS_TRANSFER_REF_LOCKS"),
+		LockTTL:   envDuration("GIT_LFS_TRANSFER_LOCK_TTL"),
+		LockStore: os.Getenv("GIT_LFS_TRANSFER_LOCK_STORE"),
+		Pool:      os.Getenv("GIT_LFS_TRANSFER_POOL"),
+		S3: S3Config{
+			Endpoint:     os.Getenv("GIT_LFS_TRANSFER_S3_ENDPOINT"),
+			Bucket:       os.Getenv("GIT_LFS_TRANSFER_S3_BUCKET"),
+			Prefix:       os.Getenv("GIT_LFS_TRANSFER_S3_PREFIX"),
+			Region:       os.Getenv("GIT_LFS_TRANSFER_S3_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+}
+
+func envBool(name string) bool {
+	b, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && b
+}
+
+func envDuration(name string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+	"github.com/git-lfs/wildmatch/v2"
+)
+
+var permissionNames = map[string]transfer.Permission{
+	"read":  transfer.PermRead,
+	"write": transfer.PermWrite | transfer.PermRead,
+	"lock":  transfer.PermLock,
+	"admin": transfer.PermAll,
+}
+
+type policyRule struct {
+	principal string
+	repo      *wildmatch.Wildmatch
+	perms     transfer.Permission
+}
+
+// Policy grants permissions on repositories. Each line of a policy file has
+// the form
+//
+//	<principal> <repository glob> <permission>[,<permission>...]
+//
+// where principal is `user:<name>`, `group:<name>`, `key:<fingerprint>` or
+// `*`, and permissions are read, write, lock and admin. Anything not granted
+// is denied.
+type Policy struct {
+	rules []policyRule
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &Policy{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected 3 fields, got %d", path, n, len(fields))
+		}
+		if fields[0] != "*" && !strings.HasPrefix(fields[0], "user:") &&
+			!strings.HasPrefix(fields[0], "group:") && !strings.HasPrefix(fields[0], "key:") {
+			return nil, fmt.Errorf("%s:%d: invalid principal %q", path, n, fields[0])
+		}
+		rule := policyRule{
+			principal: fields[0],
+			repo:      wildmatch.NewWildmatch(strings.TrimPrefix(fields[1], "/")),
+		}
+		for _, name := range strings.Split(fields[2], ",") {
+			perm, ok := permissionNames[name]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown permission %q", path, n, name)
+			}
+			rule.perms |= perm
+		}
+		p.rules = append(p.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Permissions returns everything granted to id on repo by any matching rule.
+func (p *Policy) Permissions(id *transfer.Identity, repo string) transfer.Permission {
+	var perms transfer.Permission
+	repo = strings.TrimPrefix(repo, "/")
+	for _, rule := range p.rules {
+		if rule.matches(id) && rule.repo.Match(repo) {
+			perms |= rule.perms
+		}
+	}
+	return perms
+}
+
+func (r *policyRule) matches(id *transfer.Identity) bool {
+	kind, name, _ := strings.Cut(r.principal, ":")
+	switch kind {
+	case "*":
+		return true
+	case "user":
+		return name == id.User
+	case "key":
+		return id.Key != "" && name == id.Key
+	case "group":
+		for _, g := range id.Groups {
+			if g == name {
+				return true
+			}
+		}
+	}
+	return false
+}
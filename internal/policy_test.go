@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func writePolicy(t *testing.T, policy string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPolicyPermissions(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, `# principal repository permissions
+user:alice    games/*.git   read,write,lock
+group:art     games/**      read
+key:SHA256:k  secret.git    admin
+*             public/**     read
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id       transfer.Identity
+		repo     string
+		expected transfer.Permission
+	}{
+		{transfer.Identity{User: "alice"}, "games/tetris.git", transfer.PermRead | transfer.PermWrite | transfer.PermLock},
+		{transfer.Identity{User: "alice"}, "games/arcade/pong.git", 0},
+		{transfer.Identity{User: "bob", Groups: []string{"art"}}, "games/arcade/pong.git", transfer.PermRead},
+		{transfer.Identity{User: "bob", Key: "SHA256:k"}, "secret.git", transfer.PermAll},
+		{transfer.Identity{User: "bob"}, "secret.git", 0},
+		{transfer.Identity{User: "bob"}, "/public/docs.git", transfer.PermRead},
+	}
+	for _, tt := range tests {
+		if perms := policy.Permissions(&tt.id, tt.repo); perms != tt.expected {
+			t.Errorf("%+v on %s: got %04b, want %04b", tt.id, tt.repo, perms, tt.expected)
+		}
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, policy := range []string{
+		"alice games.git read\n",
+		"user:alice games.git\n",
+		"user:alice games.git read,delete\n",
+	} {
+		if _, err := LoadPolicy(writePolicy(t, policy)); err == nil {
+			t.Errorf("%q: expected error", policy)
+		}
+	}
+}
+
+func TestTransferPolicy(t *testing.T) {
+	dir := newTestRepo(t)
+
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_LFS_TRANSFER_POLICY", writePolicy(t, "user:"+u.Username+" "+abs+" read\n"))
+
+	oid := "6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a84118090"
+	batch := []string{"batch", "transfer=ssh", "hash-algo=sha256", "delim", oid + " 6", "flush"}
+	handshake := "< version=1\n< locking\n< flush\n< status 200\n< flush\n"
+
+	expected := handshake + "< status 403\n< delim\n< permission denied\n< flush\n"
+	if result, _ := runSession(t, dir, "upload", batch...); result != expected {
+		t.Errorf("unexpected response\ngot:\n%s\nwant:\n%s", result, expected)
+	}
+
+	expected = handshake + "< status 200\n< hash-algo=sha256\n< delim\n< " + oid + " 6 noop\n< flush\n"
+	if result, _ := runSession(t, dir, "download", batch...); result != expected {
+		t.Errorf("unexpected response\ngot:\n%s\nwant:\n%s", result, expected)
+	}
+}
@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// PoolStorage stores objects once in a pool shared between repositories.
+// Each repository records a reference to the objects it holds, under
+// refs/aa/bb/<oid>/<repository key>, and an object is only removed from the
+// pool with its last reference. Objects already in the repository's own
+// storage are still served from there.
+//
+// Like Git, a repository can borrow the objects of others listed in its
+// objects/info/alternates, such as the one it was forked from. It takes a
+// reference to a borrowed object when it first uses it.
+type PoolStorage struct {
+	pool       *transfer.LocalStorage
+	local      *transfer.LocalStorage
+	objects    string
+	dir        string
+	repo       string
+	key        string
+	alternates []string
+}
+
+func NewPoolStorage(dir string, repo *transfer.Repository) (*PoolStorage, error) {
+	for _, d := range []string{"objects", "tmp", "refs"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return &PoolStorage{
+		pool:       transfer.NewLocalStorage(dir),
+		local:      transfer.NewLocalStorage(repo.LFSDir),
+		objects:    filepath.Join(repo.LFSDir, "objects"),
+		dir:        dir,
+		repo:       repo.CommonDir,
+		key:        poolKey(repo.CommonDir),
+		alternates: alternateKeys(repo.CommonDir),
+	}, nil
+}
+
+func poolKey(commonDir string) string {
+	hash := sha256.Sum256([]byte(commonDir))
+	return hex.EncodeToString(hash[:])
+}
+
+// maxAlternateDepth is how far alternates of alternates are followed, as in
+// Git.
+const maxAlternateDepth = 5
+
+// alternateKeys returns the keys of the repositories that the one at
+// commonDir borrows objects from.
+func alternateKeys(commonDir string) []string {
+	var keys []string
+	seen := map[string]bool{commonDir: true}
+	dirs := []string{commonDir}
+	for depth := 0; depth < maxAlternateDepth && len(dirs) > 0; depth++ {
+		var next []string
+		for _, dir := range dirs {
+			for _, alt := range readAlternates(dir) {
+				if !seen[alt] {
+					seen[alt] = true
+					keys = append(keys, poolKey(alt))
+					next = append(next, alt)
+				}
+			}
+		}
+		dirs = next
+	}
+	return keys
+}
+
+// readAlternates returns the repositories listed in objects/info/alternates
+// of the repository at commonDir. The file names their objects directories,
+// relative paths are taken from our own.
+func readAlternates(commonDir string) []string {
+	objects := filepath.Join(commonDir, "objects")
+	data, err := os.ReadFile(filepath.Join(objects, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objects, line)
+		}
+		dir := filepath.Dir(filepath.Clean(line))
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func (s *PoolStorage) refDir(oid string) string {
+	return filepath.Join(s.dir, "refs", oid[0:2], oid[2:4], oid)
+}
+
+func (s *PoolStorage) hasRef(oid string, key string) bool {
+	_, err := os.Stat(filepath.Join(s.refDir(oid), key))
+	return err == nil
+}
+
+// borrowed reports whether a repository this one borrows from has oid.
+func (s *PoolStorage) borrowed(oid string) bool {
+	for _, key := range s.alternates {
+		if s.hasRef(oid, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the store holding oid for this repository.
+func (s *PoolStorage) resolve(oid string) (*transfer.LocalStorage, error) {
+	if _, err := s.local.Stat(oid); err == nil {
+		return s.local, nil
+	}
+	if s.hasRef(oid, s.key) {
+		return s.pool, nil
+	}
+	if err := s.borrow(oid); err != nil {
+		return nil, err
+	}
+	return s.pool, nil
+}
+
+// borrow adds the reference of the repository to oid if a repository it
+// borrows from has one, so that the object stays in the pool once that
+// repository drops it.
+func (s *PoolStorage) borrow(oid string) error {
+	if len(s.alternates) == 0 {
+		return fmt.Errorf("%s: %w", oid, os.ErrNotExist)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !s.borrowed(oid) {
+		return fmt.Errorf("%s: %w", oid, os.ErrNotExist)
+	}
+	return s.writeRef(oid)
+}
+
+func (s *PoolStorage) Stat(oid string) (int64, error) {
+	store, err := s.resolve(oid)
+	if err != nil {
+		return 0, err
+	}
+	return store.Stat(oid)
+}
+
+func (s *PoolStorage) Open(oid string, offset int64) (io.ReadCloser, error) {
+	store, err := s.resolve(oid)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(oid, offset)
+}
+
+func (s *PoolStorage) Create(oid string) (transfer.ObjectWriter, error) {
+	w, err := s.pool.Create(oid)
+	if err != nil {
+		return nil, err
+	}
+	return &poolObjectWriter{ObjectWriter: w, s: s, oid: oid}, nil
+}
+
+func (s *PoolStorage) Delete(oid string) error {
+	if _, err := s.local.Stat(oid); err == nil {
+		return s.local.Delete(oid)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.Remove(filepath.Join(s.refDir(oid), s.key))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", oid, os.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	if refs, err := os.ReadDir(s.refDir(oid)); err != nil || len(refs) > 0 {
+		return err
+	}
+	os.Remove(s.refDir(oid))
+	return s.pool.Delete(oid)
+}
+
+func (s *PoolStorage) List(fn func(oid string, size int64) error) error {
+	err := s.local.List(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.pool.List(func(oid string, size int64) error {
+		if _, err := s.local.Stat(oid); err == nil || !(s.hasRef(oid, s.key) || s.borrowed(oid)) {
+			return nil
+		}
+		return fn(oid, size)
+	})
+}
+
+// lock serialises reference changes between sessions, so that an object
+// cannot be removed while another repository is adding a reference to it.
+func (s *PoolStorage) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, "refs.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (s *PoolStorage) Import(oid string, path string) error {
+	return s.addRef(oid, func() error { return s.pool.Import(oid, path) })
+}
+
+// addRef adds the reference of the repository to oid, then stores the
+// object with commit.
+func (s *PoolStorage) addRef(oid string, commit func() error) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.writeRef(oid); err != nil {
+		return err
+	}
+	return commit()
+}
+
+// writeRef writes the reference of the repository to oid. The caller holds
+// the lock.
+func (s *PoolStorage) writeRef(oid string) error {
+	dir := s.refDir(oid)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, s.key), []byte(s.repo+"\n"), 0644)
+}
+
+// MoveLocal moves the objects in the repository's own storage into the pool
+// and returns how many it moved.
+func (s *PoolStorage) MoveLocal() (int, error) {
+	var oids []string
+	err := s.local.List(func(oid string, size int64) error {
+		oids = append(oids, oid)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for i, oid := range oids {
+		path := filepath.Join(s.objects, oid[0:2], oid[2:4], oid)
+		err := s.addRef(oid, func() error {
+			err := s.pool.Import(oid, path)
+			if errors.Is(err, syscall.EXDEV) {
+				// the pool is on another file system
+				return s.copyLocal(oid)
+			}
+			return err
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(oids), nil
+}
+
+// copyLocal copies oid from the repository's own storage into the pool and
+// removes it there.
+func (s *PoolStorage) copyLocal(oid string) error {
+	r, err := s.local.Open(oid, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := s.pool.Create(oid)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Commit(); err != nil {
+		return err
+	}
+	return s.local.Delete(oid)
+}
+
+type poolObjectWriter struct {
+	transfer.ObjectWriter
+	s   *PoolStorage
+	oid string
+}
+
+// Commit adds the reference for the repository and moves the object into
+// the pool. Identical content that is already pooled is simply replaced, so
+// there is only ever one copy.
+func (w *poolObjectWriter) Commit() error {
+	err := w.s.addRef(w.oid, w.ObjectWriter.Commit)
+	if err != nil {
+		w.ObjectWriter.Abort()
+	}
+	return err
+}
+
+// PoolObjects moves the objects stored in the repository at path into its
+// object pool, and returns how many it moved.
+func PoolObjects(path string, opts Options) (int, error) {
+	repo, err := openRepository(path, opts)
+	if err != nil {
+		return 0, err
+	}
+	store, err := openStorage(opts, repo, repoName(opts.Root, path))
+	if err != nil {
+		return 0, err
+	}
+	pool, ok := store.(*PoolStorage)
+	if !ok {
+		return 0, fmt.Errorf("repository does not use an object pool")
+	}
+	return pool.MoveLocal()
+}
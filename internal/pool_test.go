@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func testPoolRepo(t *testing.T, dir string) *transfer.Repository {
+	t.Helper()
+	initGitDir(t, dir, "[core]\n\tbare = true\n")
+	repo, err := transfer.OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func storeTestObject(t *testing.T, store transfer.Storage) {
+	t.Helper()
+	w, err := store.Create(testOid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, testContent)
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolStorage(t *testing.T) {
+	root := t.TempDir()
+	pool := filepath.Join(root, "pool")
+
+	store, err := NewPoolStorage(pool, testPoolRepo(t, filepath.Join(root, "a.git")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, store)
+}
+
+func TestPoolStorageSharing(t *testing.T) {
+	root := t.TempDir()
+	pool := filepath.Join(root, "pool")
+
+	a, err := NewPoolStorage(pool, testPoolRepo(t, filepath.Join(root, "a.git")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPoolStorage(pool, testPoolRepo(t, filepath.Join(root, "b.git")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storeTestObject(t, a)
+	if _, err := b.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("object visible to an unrelated repository: %v", err)
+	}
+	storeTestObject(t, b)
+
+	copies := 0
+	for _, dir := range []string{pool, filepath.Join(root, "a.git", "lfs"), filepath.Join(root, "b.git", "lfs")} {
+		filepath.Walk(filepath.Join(dir, "objects"), func(path string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				copies++
+			}
+			return nil
+		})
+	}
+	if copies != 1 {
+		t.Errorf("expected one copy of the object, found %d", copies)
+	}
+
+	if err := a.Delete(testOid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("object still visible after delete: %v", err)
+	}
+	if size, err := b.Stat(testOid); err != nil || size != int64(len(testContent)) {
+		t.Fatalf("object removed while still referenced: %v", err)
+	}
+
+	if err := b.Delete(testOid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.pool.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unreferenced object left in the pool: %v", err)
+	}
+}
+
+func TestPoolStorageAlternates(t *testing.T) {
+	root := t.TempDir()
+	pool := filepath.Join(root, "pool")
+
+	parent, err := NewPoolStorage(pool, testPoolRepo(t, filepath.Join(root, "parent.git")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeTestObject(t, parent)
+
+	forkDir := filepath.Join(root, "fork.git")
+	initGitDir(t, forkDir, "[core]\n\tbare = true\n")
+	if err := os.MkdirAll(filepath.Join(forkDir, "objects", "info"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(forkDir, "objects", "info", "alternates"), []byte("../../parent.git/objects\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := NewPoolStorage(pool, testPoolRepo(t, forkDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewPoolStorage(pool, testPoolRepo(t, filepath.Join(root, "other.git")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := 0
+	fork.List(func(oid string, size int64) error {
+		listed++
+		return nil
+	})
+	if listed != 1 {
+		t.Errorf("fork lists %d objects, want 1", listed)
+	}
+	if size, err := fork.Stat(testOid); err != nil || size != int64(len(testContent)) {
+		t.Fatalf("fork cannot see the object of its parent: %v", err)
+	}
+	if _, err := other.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("object visible to an unrelated repository: %v", err)
+	}
+
+	// the fork took a reference when it used the object
+	if err := parent.Delete(testOid); err != nil {
+		t.Fatal(err)
+	}
+	r, err := fork.Open(testOid, 0)
+	if err != nil {
+		t.Fatalf("object removed while the fork uses it: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != testContent {
+		t.Errorf("got %q, want %q", data, testContent)
+	}
+}
+
+func TestPoolObjects(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "a.git")
+	repo := testPoolRepo(t, dir)
+	for _, d := range []string{"objects", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(repo.LFSDir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storeTestObject(t, transfer.NewLocalStorage(repo.LFSDir))
+
+	opts := Options{Pool: filepath.Join(root, "pool")}
+	n, err := PoolObjects(dir, opts)
+	if err != nil || n != 1 {
+		t.Fatalf("moved %d objects: %v", n, err)
+	}
+	if _, err := transfer.NewLocalStorage(repo.LFSDir).Stat(testOid); !os.IsNotExist(err) {
+		t.Errorf("object left in the repository: %v", err)
+	}
+	store, err := NewPoolStorage(opts.Pool, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.hasRef(testOid, store.key) {
+		t.Errorf("object was moved without a reference")
+	}
+	if size, err := store.Stat(testOid); err != nil || size != int64(len(testContent)) {
+		t.Errorf("object not found in the pool: %v", err)
+	}
+
+	if _, err := PoolObjects(dir, Options{}); err == nil {
+		t.Errorf("objects moved without a pool")
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// lockTTL returns the lifetime of new locks in repo, set with
+// lfs-transfer.lockTTL or opts.LockTTL.
+func lockTTL(opts Options, repo *transfer.Repository) (time.Duration, error) {
+	v, ok := repo.Config("lfs-transfer.lockttl")
+	if !ok {
+		return opts.LockTTL, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid lfs-transfer.lockTTL %q", v)
+	}
+	return d, nil
+}
+
+// ReapLocks removes the expired locks of the repository at path and returns
+// them. Every removal is recorded in lfs/logs/locks.log.
+func ReapLocks(path string, opts Options) ([]*transfer.Lock, error) {
+	repo, err := openRepository(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := lockTTL(opts, repo)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openLockStore(opts, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	var ids []string
+	now := time.Now()
+	err = store.List(transfer.LockFilter{}, func(lock *transfer.Lock) error {
+		if lock.Expired(now, ttl) {
+			ids = append(ids, lock.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reaped := []*transfer.Lock{}
+	for _, id := range ids {
+		lock, err := transfer.ExpireLock(store, repo.LFSDir, id, now, ttl, nil)
+		if lock != nil {
+			reaped = append(reaped, lock)
+		}
+		if err != nil {
+			return reaped, err
+		}
+	}
+	return reaped, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func writeLock(t *testing.T, dir string, lock *transfer.Lock) {
+	t.Helper()
+	if err := transfer.NewFileLockStore(filepath.Join(dir, ".git", "lfs")).Create(lock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	t.Setenv("LFS_USER", "bob")
+	t.Setenv("GIT_LFS_TRANSFER_LOCK_TTL", "24h")
+
+	// create the lfs directories
+	runSession(t, dir, "upload")
+
+	id := "c7b8de23fd238fe5e16f6f03b844022f9f72fd168a0704d82d58f19cf72b7aa3"
+	writeLock(t, dir, &transfer.Lock{ID: id, Path: "test.zip", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"})
+
+	result, _ := runSession(t, dir, "download", "list-lock", "flush")
+	if strings.Contains(result, "< lock "+id) {
+		t.Errorf("expired lock was listed:\n%s", result)
+	}
+
+	result, _ = runSession(t, dir, "upload", "lock", "path=test.zip", "flush")
+	if !strings.Contains(result, "< status 201\n") || !strings.Contains(result, "< ownername=bob\n") {
+		t.Errorf("expired lock was not replaced:\n%s", result)
+	}
+
+	lock, err := transfer.NewFileLockStore(filepath.Join(dir, ".git", "lfs")).Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Owner != "bob" || lock.ExpiresAt == "" || lock.Expired(time.Now(), 0) {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, ".git", "lfs", "logs", "locks.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"action":"expire"`, `"owner":"alice"`, `"user":"bob"`} {
+		if !strings.Contains(string(b), field) {
+			t.Errorf("expiry not recorded, %s missing in %s", field, b)
+		}
+	}
+}
+
+func TestReapLocks(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	t.Setenv("LFS_USER", "bob")
+
+	runSession(t, dir, "upload")
+
+	writeLock(t, dir, &transfer.Lock{ID: "1", Path: "a.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z", ExpiresAt: "2023-01-03T03:04:05Z"})
+	writeLock(t, dir, &transfer.Lock{ID: "2", Path: "b.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"})
+	writeLock(t, dir, &transfer.Lock{ID: "3", Path: "c.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z", ExpiresAt: "2999-01-01T00:00:00Z"})
+
+	reaped, err := ReapLocks(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].ID != "1" {
+		t.Errorf("expected lock 1 to be reaped, got %+v", reaped)
+	}
+
+	reaped, err = ReapLocks(dir, Options{LockTTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].ID != "2" {
+		t.Errorf("expected lock 2 to be reaped, got %+v", reaped)
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, ".git", "lfs", "locks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "3" {
+		t.Errorf("unexpected locks left: %v", files)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, ".git", "lfs", "logs", "locks.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), `"action":"expire"`) != 2 || strings.Contains(string(b), `"user"`) {
+		t.Errorf("unexpected log: %s", b)
+	}
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+var errOutsideRoot = errors.New("repository is outside of the server root")
+
+// resolveRepoPath canonicalises the path requested by the client. Relative
+// paths are taken relative to root if one is set, and the result must not
+// escape root once symlinks are resolved.
+func resolveRepoPath(root string, path string) (string, error) {
+	if root == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return "", transfer.ErrRepoNotFound
+		}
+		return real, nil
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	if !isWithin(root, path) {
+		return "", errOutsideRoot
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", transfer.ErrRepoNotFound
+	}
+	if !isWithin(root, real) {
+		return "", errOutsideRoot
+	}
+	return real, nil
+}
+
+// repoName is the name policies refer to a repository by: its path below
+// root, or its full path if there is no root.
+func repoName(root string, path string) string {
+	if root == "" {
+		return path
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// openRepository opens the repository at path, as requested by a client or
+// a maintenance command. Both path and the Git directory it resolves to, which
+// a .git file may point anywhere, must be within root.
+func openRepository(path string, opts Options) (*transfer.Repository, error) {
+	path, err := resolveRepoPath(opts.Root, path)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := transfer.OpenRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		for _, dir := range []string{repo.GitDir, repo.CommonDir} {
+			if _, err := resolveRepoPath(opts.Root, dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return repo, nil
+}
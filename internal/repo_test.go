@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+func initGitDir(t *testing.T, dir string, config string) {
+	t.Helper()
+	for _, d := range []string{"objects", "refs"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRepo returns a new non-bare repository.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "test")
+	initGitDir(t, filepath.Join(dir, ".git"), "[core]\n\tbare = false\n")
+	return dir
+}
+
+func TestResolveRepoPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	initGitDir(t, filepath.Join(root, "foo.git"), "[core]\n\tbare = true\n")
+	initGitDir(t, filepath.Join(outside, "bar.git"), "[core]\n\tbare = true\n")
+	if err := os.Symlink(filepath.Join(outside, "bar.git"), filepath.Join(root, "link.git")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		err  error
+	}{
+		{"foo.git", nil},
+		{filepath.Join(root, "foo.git"), nil},
+		{"../" + filepath.Base(outside) + "/bar.git", errOutsideRoot},
+		{"foo.git/../../" + filepath.Base(outside) + "/bar.git", errOutsideRoot},
+		{filepath.Join(outside, "bar.git"), errOutsideRoot},
+		{"link.git", errOutsideRoot},
+		{"missing.git", transfer.ErrRepoNotFound},
+	}
+	for _, tt := range tests {
+		_, err := resolveRepoPath(root, tt.path)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestTransferRejectsPaths(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	t.Setenv("GIT_LFS_TRANSFER_ROOT", root)
+
+	if err := os.MkdirAll(filepath.Join(root, "plain"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	initGitDir(t, filepath.Join(outside, "bar.git"), "[core]\n\tbare = true\n")
+	if err := os.MkdirAll(filepath.Join(root, "gitfile"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "gitfile", ".git"), []byte("gitdir: "+filepath.Join(outside, "bar.git")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_DIR", filepath.Join(outside, "bar.git"))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"../escape", "< status 403\n< delim\n< repository is outside of the server root\n< flush\n"},
+		{"plain", "< status 404\n< delim\n< repository not found\n< flush\n"},
+		{"gitfile", "< status 403\n< delim\n< repository is outside of the server root\n< flush\n"},
+	}
+	for _, tt := range tests {
+		result, err := runSession(t, tt.path, "upload")
+		if err == nil {
+			t.Errorf("%s: expected error", tt.path)
+		}
+		if expected := "< version=1\n< locking\n< flush\n" + tt.expected; result != expected {
+			t.Errorf("%s: unexpected response\ngot:\n%s\nwant:\n%s", tt.path, result, expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "plain", ".git")); !os.IsNotExist(err) {
+		t.Errorf("directories were created for a path that is not a repository")
+	}
+}
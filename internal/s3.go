@@ -0,0 +1,443 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+type S3Config struct {
+	Endpoint     string
+	Bucket       string
+	Prefix       string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3Storage keeps objects in an S3-compatible bucket, using path-style
+// requests signed with AWS Signature Version 4. Uploads are spooled to tmp
+// first, as S3 needs to know the length up front. Objects larger than a part
+// are uploaded in parts, since a single PUT is limited to 5 GB.
+type S3Storage struct {
+	cfg      S3Config
+	tmp      string
+	client   *http.Client
+	partSize int64
+}
+
+const (
+	s3PartSize = 64 << 20
+	// s3MaxParts is the most parts a multipart upload may have.
+	s3MaxParts = 10000
+)
+
+func NewS3Storage(cfg S3Config, tmp string) *S3Storage {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &S3Storage{cfg: cfg, tmp: tmp, client: http.DefaultClient, partSize: s3PartSize}
+}
+
+func (s *S3Storage) key(oid string) string {
+	return path.Join(s.cfg.Prefix, oid[0:2], oid[2:4], oid)
+}
+
+func (s *S3Storage) Stat(oid string) (int64, error) {
+	resp, err := s.do(http.MethodHead, s.key(oid), nil, nil, nil, -1, unsignedPayload)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *S3Storage) Open(oid string, offset int64) (io.ReadCloser, error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := s.do(http.MethodGet, s.key(oid), nil, header, nil, -1, unsignedPayload)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		// the whole object, which must not be sent as what follows offset
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 GET %s: range not satisfied: %s", s.key(oid), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Create(oid string) (transfer.ObjectWriter, error) {
+	f, err := os.CreateTemp(s.tmp, "s3")
+	if err != nil {
+		return nil, err
+	}
+	return &s3ObjectWriter{s: s, oid: oid, f: f, hash: sha256.New()}, nil
+}
+
+func (s *S3Storage) Delete(oid string) error {
+	resp, err := s.do(http.MethodDelete, s.key(oid), nil, nil, nil, -1, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3Storage) List(fn func(oid string, size int64) error) error {
+	query := url.Values{"list-type": {"2"}}
+	if s.cfg.Prefix != "" {
+		query.Set("prefix", strings.TrimSuffix(s.cfg.Prefix, "/")+"/")
+	}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, -1, unsignedPayload)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			if err := fn(path.Base(obj.Key), obj.Size); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Import uploads the verified object at path straight from the file, whose
+// hash is the OID, and removes it.
+func (s *S3Storage) Import(oid string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.put(s.key(oid), f, fi.Size(), oid); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// put uploads the size bytes of f, whose SHA-256 is payloadHash, as key, in
+// parts if it is larger than a part.
+func (s *S3Storage) put(key string, f *os.File, size int64, payloadHash string) error {
+	if size > s.partSize {
+		return s.putMultipart(key, f, size)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := s.do(http.MethodPut, key, nil, header, f, size, payloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ObjectWriter struct {
+	s    *S3Storage
+	oid  string
+	f    *os.File
+	hash hash.Hash
+	size int64
+}
+
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *s3ObjectWriter) Commit() error {
+	defer os.Remove(w.f.Name())
+	defer w.f.Close()
+	return w.s.put(w.s.key(w.oid), w.f, w.size, hex.EncodeToString(w.hash.Sum(nil)))
+}
+
+func (w *s3ObjectWriter) Abort() error {
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+type s3InitiateResult struct {
+	UploadId string
+}
+
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3CompleteRequest struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompleteResult struct {
+	XMLName xml.Name
+	Code    string
+	Message string
+}
+
+// putMultipart uploads the size bytes of f as key in parts. A failed upload
+// is aborted, so that its parts do not linger in the bucket.
+func (s *S3Storage) putMultipart(key string, f io.ReaderAt, size int64) error {
+	partSize := s.partSize
+	if least := (size + s3MaxParts - 1) / s3MaxParts; partSize < least {
+		partSize = least
+	}
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, 0, emptyPayload)
+	if err != nil {
+		return err
+	}
+	var initiated s3InitiateResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("s3 POST %s: %s", key, err)
+	}
+	if initiated.UploadId == "" {
+		return fmt.Errorf("s3 POST %s: missing upload ID", key)
+	}
+
+	err = s.putParts(key, initiated.UploadId, f, size, partSize)
+	if err != nil {
+		resp, abortErr := s.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadId}}, nil, nil, -1, unsignedPayload)
+		if abortErr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+func (s *S3Storage) putParts(key string, uploadID string, f io.ReaderAt, size int64, partSize int64) error {
+	var complete s3CompleteRequest
+	for offset, n := int64(0), 1; offset < size; offset, n = offset+partSize, n+1 {
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, length)); err != nil {
+			return err
+		}
+		query := url.Values{"partNumber": {fmt.Sprint(n)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, key, query, nil, io.NewSectionReader(f, offset, length), length, hex.EncodeToString(h.Sum(nil)))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, header, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// completing can fail after the response started, with an error body
+	var result s3CompleteResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("s3 POST %s: %s", key, err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 POST %s: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// emptyPayload is the SHA-256 of an empty body.
+	emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func (s *S3Storage) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %w", method, key, os.ErrNotExist)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "range" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// required for canonical requests.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = s3Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
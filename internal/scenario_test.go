@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// Scenarios in testdata/scenarios describe sessions of the SSH adapter in a
+// readable form. Directives before the first session set up the repository:
+//
+//	now 2023-01-02T03:04:05Z          clock of the server
+//	env NAME value                    environment variable
+//	config section.key value          git config of the repository
+//	policy user:bob ** read           line of the access policy
+//	object content                    object stored in the repository
+//	partial oid content               interrupted upload of oid
+//	lock path owner locked-at [ref]   existing lock
+//
+// "session operation [user]" starts a session, by default of user alice.
+// Its packets follow, "> " for those sent by the client and "< " for those
+// expected from the server, in the order they are sent. A packet is
+// "flush", "delim", "data content" for data without a trailing newline,
+// "raw content" for bytes sent as is, or else a line of text.
+//
+// "expect" directives check the repository after all sessions ran:
+//
+//	expect object oid size
+//	expect no object oid
+//	expect lock path owner
+//	expect no lock path
+//	expect no partial oid
+//
+// Arguments and data may be Go quoted strings. Many scenarios are based on
+// the tests of https://github.com/bk2204/scutiger/tree/dev/scutiger-lfs.
+
+type scenarioSession struct {
+	operation string
+	user      string
+	input     bytes.Buffer
+	output    bytes.Buffer
+}
+
+type scenario struct {
+	now      time.Time
+	env      [][2]string
+	config   []string
+	policy   []string
+	objects  []string
+	partials [][2]string
+	locks    []*transfer.Lock
+	sessions []*scenarioSession
+	expects  [][]string
+}
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenarios found")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".txt"), func(t *testing.T) {
+			sc, err := parseScenario(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runScenario(t, sc)
+		})
+	}
+}
+
+func runScenario(t *testing.T, sc *scenario) {
+	dir := filepath.Join(t.TempDir(), "repo")
+	config := "[core]\n\tbare = false\n"
+	for _, line := range sc.config {
+		config += line
+	}
+	initGitDir(t, filepath.Join(dir, ".git"), config)
+	lfsPath := filepath.Join(dir, ".git", "lfs")
+	for _, d := range []string{"objects", "incomplete", "tmp", "locks"} {
+		if err := os.MkdirAll(filepath.Join(lfsPath, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := transfer.NewLocalStorage(lfsPath)
+	for _, content := range sc.objects {
+		w, err := store.Create(scenarioOid(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, partial := range sc.partials {
+		if err := os.WriteFile(filepath.Join(lfsPath, "incomplete", partial[0]), []byte(partial[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locks := transfer.NewFileLockStore(lfsPath)
+	for _, lock := range sc.locks {
+		if err := locks.Create(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("GIT_LFS_TRANSFER_USER_ENV", "LFS_USER")
+	for _, env := range sc.env {
+		t.Setenv(env[0], env[1])
+	}
+	if len(sc.policy) > 0 {
+		t.Setenv("GIT_LFS_TRANSFER_POLICY", writePolicy(t, strings.Join(sc.policy, "\n")+"\n"))
+	}
+
+	for i, sess := range sc.sessions {
+		t.Setenv("LFS_USER", sess.user)
+		opts := OptionsFromEnv()
+		if !sc.now.IsZero() {
+			opts.Now = func() time.Time { return sc.now }
+		}
+		result := new(bytes.Buffer)
+		TransferWithOptions(bytes.NewReader(sess.input.Bytes()), result, []string{"", dir, sess.operation}, opts)
+		if !bytes.Equal(result.Bytes(), sess.output.Bytes()) {
+			t.Errorf("session %d: unexpected response\ngot:\n%s\nwant:\n%s", i+1, renderPackets(result.Bytes()), renderPackets(sess.output.Bytes()))
+		}
+	}
+
+	for _, expect := range sc.expects {
+		switch {
+		case len(expect) == 3 && expect[0] == "object":
+			size, err := store.Stat(expect[1])
+			if err != nil || strconv.FormatInt(size, 10) != expect[2] {
+				t.Errorf("expected object %s of %s bytes, got %d bytes, %v", expect[1], expect[2], size, err)
+			}
+		case len(expect) == 3 && expect[0] == "no" && expect[1] == "object":
+			if _, err := store.Stat(expect[2]); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("unexpected object %s: %v", expect[2], err)
+			}
+		case len(expect) == 3 && expect[0] == "lock":
+			lock, err := locks.Get(transfer.LockID(expect[1], "", false))
+			if err != nil || lock.Owner != expect[2] {
+				t.Errorf("expected lock on %s owned by %s, got %+v, %v", expect[1], expect[2], lock, err)
+			}
+		case len(expect) == 3 && expect[0] == "no" && expect[1] == "lock":
+			if lock, err := locks.Get(transfer.LockID(expect[2], "", false)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("unexpected lock on %s: %+v, %v", expect[2], lock, err)
+			}
+		case len(expect) == 3 && expect[0] == "no" && expect[1] == "partial":
+			if _, err := os.Stat(filepath.Join(lfsPath, "incomplete", expect[2])); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("unexpected partial upload of %s: %v", expect[2], err)
+			}
+		default:
+			t.Fatalf("invalid expectation %q", expect)
+		}
+	}
+}
+
+func parseScenario(path string) (*scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := &scenario{}
+	var sess *scenarioSession
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "> ") || strings.HasPrefix(line, "< ") {
+			if sess == nil {
+				return nil, fmt.Errorf("%s:%d: packet outside of a session", path, n)
+			}
+			w := &sess.input
+			if line[0] == '<' {
+				w = &sess.output
+			}
+			if err := writePacket(w, line[2:]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		directive, args := fields[0], fields[1:]
+		switch {
+		case directive == "session" && (len(args) == 1 || len(args) == 2):
+			sess = &scenarioSession{operation: args[0], user: "alice"}
+			if len(args) == 2 {
+				sess.user = args[1]
+			}
+			sc.sessions = append(sc.sessions, sess)
+		case directive == "expect" && len(args) > 0:
+			sc.expects = append(sc.expects, args)
+		case sess != nil:
+			return nil, fmt.Errorf("%s:%d: %s after the first session", path, n, directive)
+		case directive == "now" && len(args) == 1:
+			sc.now, err = time.Parse(time.RFC3339, args[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+		case directive == "env" && len(args) == 2:
+			sc.env = append(sc.env, [2]string{args[0], args[1]})
+		case directive == "config" && len(args) == 2:
+			i := strings.LastIndex(args[0], ".")
+			if i < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid config key %q", path, n, args[0])
+			}
+			sc.config = append(sc.config, fmt.Sprintf("[%s]\n\t%s = %s\n", args[0][:i], args[0][i+1:], args[1]))
+		case directive == "policy":
+			sc.policy = append(sc.policy, strings.Join(args, " "))
+		case directive == "object" && len(args) == 1:
+			sc.objects = append(sc.objects, args[0])
+		case directive == "partial" && len(args) == 2:
+			sc.partials = append(sc.partials, [2]string{args[0], args[1]})
+		case directive == "lock" && (len(args) == 3 || len(args) == 4):
+			lock := &transfer.Lock{Path: args[0], Owner: args[1], LockedAt: args[2]}
+			if len(args) == 4 {
+				lock.Refname = args[3]
+			}
+			lock.ID = transfer.LockID(lock.Path, lock.Refname, lock.Refname != "")
+			sc.locks = append(sc.locks, lock)
+		default:
+			return nil, fmt.Errorf("%s:%d: invalid directive %q", path, n, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// sessionInput returns what a client sends to run the requests described by
+// packets, see TestScenarios, after selecting version 1.
+func sessionInput(t *testing.T, packets ...string) []byte {
+	t.Helper()
+	input := new(bytes.Buffer)
+	for _, packet := range append([]string{"version 1", "flush"}, packets...) {
+		if err := writePacket(input, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return input.Bytes()
+}
+
+// runSession runs a session of operation on the repository at dir with the
+// requests described by packets, and returns the response the way scenarios
+// describe it.
+func runSession(t *testing.T, dir string, operation string, packets ...string) (string, error) {
+	t.Helper()
+	result := new(bytes.Buffer)
+	err := Transfer(bytes.NewReader(sessionInput(t, packets...)), result, []string{"", dir, operation})
+	return renderPackets(result.Bytes()), err
+}
+
+// writePacket writes the packet described by s, see TestScenarios.
+func writePacket(w *bytes.Buffer, s string) error {
+	switch {
+	case s == "flush":
+		w.WriteString("0000")
+	case s == "delim":
+		w.WriteString("0001")
+	case strings.HasPrefix(s, "data "), strings.HasPrefix(s, "raw "):
+		kind, arg, _ := strings.Cut(s, " ")
+		data, err := unquote(arg)
+		if err != nil {
+			return err
+		}
+		if kind == "data" {
+			fmt.Fprintf(w, "%04x", len(data)+4)
+		}
+		w.WriteString(data)
+	default:
+		fmt.Fprintf(w, "%04x%s\n", len(s)+5, s)
+	}
+	return nil
+}
+
+// renderPackets describes the packets in b the way scenarios do.
+func renderPackets(b []byte) string {
+	out := new(strings.Builder)
+	for len(b) > 0 {
+		if len(b) < 4 {
+			fmt.Fprintf(out, "< raw %q\n", b)
+			break
+		}
+		n, err := strconv.ParseUint(string(b[:4]), 16, 16)
+		switch {
+		case err != nil || (n > 1 && n < 4) || int(n) > len(b):
+			fmt.Fprintf(out, "< raw %q\n", b)
+			return out.String()
+		case n == 0:
+			out.WriteString("< flush\n")
+			n = 4
+		case n == 1:
+			out.WriteString("< delim\n")
+			n = 4
+		default:
+			payload := string(b[4:n])
+			text, ok := strings.CutSuffix(payload, "\n")
+			if ok && strconv.CanBackquote(text) && !strings.HasPrefix(text, "data ") && !strings.HasPrefix(text, "raw ") {
+				fmt.Fprintf(out, "< %s\n", text)
+			} else {
+				fmt.Fprintf(out, "< data %q\n", payload)
+			}
+		}
+		b = b[n:]
+	}
+	return out.String()
+}
+
+// splitFields splits s at spaces, keeping Go quoted strings together.
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		end := strings.IndexByte(s, ' ')
+		if s[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string in %q", s)
+			}
+			end = len(quoted)
+		} else if end < 0 {
+			end = len(s)
+		}
+		field, err := unquote(s[:end])
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		s = s[end:]
+	}
+	return fields, nil
+}
+
+func unquote(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+func scenarioOid(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
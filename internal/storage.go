@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+// openStorage returns the configured object store of repo. Repositories
+// sharing a bucket are kept apart by their name unless the prefix is set
+// with lfs-transfer.s3prefix.
+func openStorage(opts Options, repo *transfer.Repository, name string) (transfer.Storage, error) {
+	if opts.S3.Bucket == "" {
+		pool := opts.Pool
+		if dir, ok := repo.Config("lfs-transfer.pool"); ok {
+			pool = dir
+		}
+		if pool == "" {
+			return transfer.NewLocalStorage(repo.LFSDir), nil
+		}
+		if !filepath.IsAbs(pool) {
+			pool = filepath.Join(repo.CommonDir, pool)
+		}
+		return NewPoolStorage(pool, repo)
+	}
+	cfg := opts.S3
+	if prefix, ok := repo.Config("lfs-transfer.s3prefix"); ok {
+		cfg.Prefix = prefix
+	} else {
+		cfg.Prefix = path.Join(cfg.Prefix, strings.TrimPrefix(name, "/"))
+	}
+	return NewS3Storage(cfg, filepath.Join(repo.LFSDir, "tmp")), nil
+}
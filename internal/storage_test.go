@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
+)
+
+const (
+	testOid     = "6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a84118090"
+	testContent = "abc123"
+)
+
+func testStorage(t *testing.T, store transfer.Storage) {
+	t.Helper()
+
+	if _, err := store.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing object, got %v", err)
+	}
+
+	w, err := store.Create(testOid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "garbage")
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(testOid); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("aborted object is visible: %v", err)
+	}
+
+	w, err = store.Create(testOid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, testContent)
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := store.Stat(testOid)
+	if err != nil || size != int64(len(testContent)) {
+		t.Fatalf("got size %d (%v), want %d", size, err, len(testContent))
+	}
+
+	for _, offset := range []int64{0, 3} {
+		r, err := store.Open(testOid, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != testContent[offset:] {
+			t.Fatalf("got content %q (%v) at offset %d, want %q", b, err, offset, testContent[offset:])
+		}
+	}
+
+	var oids []string
+	err = store.List(func(oid string, size int64) error {
+		oids = append(oids, oid)
+		return nil
+	})
+	if err != nil || len(oids) != 1 || oids[0] != testOid {
+		t.Fatalf("got objects %q (%v), want %q", oids, err, testOid)
+	}
+
+	if err := store.Delete(testOid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(testOid, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("deleted object is visible: %v", err)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"objects", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testStorage(t, transfer.NewLocalStorage(dir))
+}
@@ -1,19 +1,60 @@
 package internal
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/autovia/git-lfs-transfer/transfer"
 )
 
 func Transfer(r io.Reader, w io.Writer, args []string) error {
-	lfsPath := strings.Replace(strings.Replace(args[1], "'/", "", -1), "'", "", -1) + "/.git/lfs"
+	return TransferWithOptions(r, w, args, OptionsFromEnv())
+}
+
+func TransferWithOptions(r io.Reader, w io.Writer, args []string, opts Options) error {
+	path := args[1]
+	if strings.Contains(path, "'") {
+		// the client's quoted command line, not unquoted by a shell
+		path = clientRepoPath(strings.Replace(path, "'", "", -1))
+	}
+	path, err := resolveRepoPath(opts.Root, path)
+	var repo *transfer.Repository
+	if err == nil {
+		repo, err = openRepository(path, opts)
+	}
+	if err != nil {
+		status := "status 404"
+		if errors.Is(err, errOutsideRoot) {
+			status = "status 403"
+		}
+		transfer.NewPktlineChannel(r, w).Refuse(status, err.Error())
+		return err
+	}
+	lfsPath := repo.LFSDir
+
+	id, err := currentIdentity(opts)
+	if err != nil {
+		transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot determine user")
+		return err
+	}
+
+	perms := transfer.PermDefault
+	if opts.Policy != "" {
+		policy, err := LoadPolicy(opts.Policy)
+		if err != nil {
+			transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot load access policy")
+			return err
+		}
+		perms = policy.Permissions(id, repoName(opts.Root, path))
+	}
 
 	if _, err := os.Stat(lfsPath); os.IsNotExist(err) {
-		err := os.MkdirAll(lfsPath, os.ModePerm) // .git/lfs
+		err := os.MkdirAll(lfsPath, os.ModePerm) // <git-dir>/lfs
 		if err != nil {
+			transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot create lfs directory")
 			return err
 		}
 	}
@@ -23,126 +64,39 @@ func Transfer(r io.Reader, w io.Writer, args []string) error {
 		if _, err := os.Stat(filepath.Join(lfsPath, lfsDir)); os.IsNotExist(err) {
 			err := os.MkdirAll(lfsPath+"/"+lfsDir, os.ModePerm)
 			if err != nil {
+				transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot create lfs directory")
 				return err
 			}
 		}
 	}
 
-	cmd := args[2]
-	c := NewPktlineChannel(r, w, lfsPath)
-	c.fs.c = c
-	err := c.Start()
+	ttl, err := lockTTL(opts, repo)
 	if err != nil {
+		transfer.NewPktlineChannel(r, w).Refuse("status 500", err.Error())
 		return err
 	}
-	for c.Scan() {
-		if c.req.err == io.EOF {
-			// nothing to read - EOF channel
-			break
-		}
-		if len(c.req.args) == 0 {
-			// nothing to read, args empty
-			continue
-		}
-		if len(c.req.args) > 2 {
-			if strings.HasPrefix(c.req.args[2], "hash-algo=") {
-				if c.req.args[2] != "hash-algo=sha256" {
-					return c.SendMessage([]string{"status 400"}, []string{"unsupported hash algorithm"})
-				}
-			}
-		}
-		if c.req.args[0] == "quit" {
-			err = c.End()
-			if err != nil {
-				return err
-			}
-			break
-		}
-		if c.req.args[0] == "version 1" {
-			err = c.SendMessage([]string{"status 200"}, nil)
-			if err != nil {
-				return err
-			}
-		}
-		if c.req.args[0] == "list-lock" || c.req.args[0] == "list-locks" {
-			msgs, err := c.fs.listLocks()
-			if err != nil {
-				return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-			}
-			err = c.SendMessage([]string{"status 202"}, msgs)
-			if err != nil {
-				return err
-			}
-		}
-		if c.req.args[0] == "batch" {
-			files, err := c.fs.batchObjects(cmd)
-			if err != nil {
-				return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-			}
-			err = c.SendMessage([]string{"status 200", "hash-algo=sha256"}, files)
-			if err != nil {
-				return err
-			}
-		}
-		if strings.HasPrefix(c.req.args[0], "verify-object") {
-			err = c.fs.verifyObject()
-			if err != nil {
-				return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-			} else {
-				err = c.SendMessage([]string{"status 200"}, nil)
-				if err != nil {
-					continue
-				}
-			}
-		}
-		if strings.HasPrefix(c.req.args[0], "get-object") {
-			errc := make(chan error, 1)
 
-			go func() {
-				errc <- c.fs.getObject()
-			}()
+	store, err := openStorage(opts, repo, repoName(opts.Root, path))
+	if err != nil {
+		transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot open object storage")
+		return err
+	}
 
-			err := <-errc
-			if err != nil {
-				err = c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-				if err != nil {
-					return err
-				}
-			}
-		}
-		if strings.HasPrefix(c.req.args[0], "put-object") {
-			err = c.fs.storeObject()
-			if err != nil {
-				return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-			} else {
-				err = c.SendMessage([]string{"status 200"}, nil)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		if strings.HasPrefix(c.req.args[0], "lock") {
-			msgs, err := c.fs.lockObject()
-			if err != nil {
-				return c.SendMessage([]string{"status 409"}, append(msgs, []string{fmt.Sprintf("%s", err)}...))
-			} else {
-				err = c.SendMessage(append([]string{"status 201"}, msgs...), nil)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		if strings.HasPrefix(c.req.args[0], "unlock") {
-			msgs, err := c.fs.unlockObject()
-			if err != nil {
-				return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
-			} else {
-				err = c.SendMessage(append([]string{"status 200"}, msgs...), nil)
-				if err != nil {
-					return err
-				}
-			}
-		}
+	locks, err := openLockStore(opts, repo)
+	if err != nil {
+		transfer.NewPktlineChannel(r, w).Refuse("status 500", "cannot open lock store")
+		return err
 	}
-	return nil
+	defer locks.Close()
+
+	server := transfer.NewServer(repo, store, locks, id)
+	server.Permissions = perms
+	server.ReadOnly = opts.ReadOnly || repo.ConfigBool("lfs-transfer.readonly", false)
+	server.RefLocks = opts.RefLocks || repo.ConfigBool("lfs-transfer.reflocks", false)
+	server.LockTTL = ttl
+	server.Now = opts.Now
+	return server.Serve(struct {
+		io.Reader
+		io.Writer
+	}{r, w}, args[2])
 }
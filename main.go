@@ -1,15 +1,47 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/autovia/git-lfs-transfer/internal"
 )
 
 func main() {
-	args := os.Args
-	if len(args) < 2 {
+	opts := internal.OptionsFromEnv()
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, help()) }
+	flag.BoolVar(&opts.ReadOnly, "read-only", opts.ReadOnly, "")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "reap-locks":
+		reapLocks(opts)
+	case "lock-log":
+		lockLog(opts)
+	case "hook":
+		hook(opts)
+	case "pool-objects":
+		poolObjects(opts)
+	}
+
+	args := append([]string{os.Args[0]}, flag.Args()...)
+	if len(args) == 1 {
+		// forced command from authorized_keys, the client's command line is
+		// only available from the environment
+		if cmd, ok := os.LookupEnv("SSH_ORIGINAL_COMMAND"); ok {
+			var err error
+			args, err = internal.ParseCommand(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: invalid SSH_ORIGINAL_COMMAND: %s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if len(args) < 3 {
 		fmt.Print(help())
 		fmt.Fprintf(os.Stderr, "fatal: expected 2 arguments, got %d\n", len(args)-1)
 		os.Exit(1)
@@ -24,22 +56,135 @@ func main() {
 	errc := make(chan error, 1)
 
 	go func() {
-		errc <- internal.Transfer(os.Stdin, os.Stdout, args)
+		errc <- internal.TransferWithOptions(os.Stdin, os.Stdout, args, opts)
 	}()
 
 	err := <-errc
 	if err != nil {
-		fmt.Print(help())
+		// stdout carries the session, help would be taken for a response
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	os.Exit(0)
 }
 
+func reapLocks(opts internal.Options) {
+	if flag.NArg() != 2 {
+		fmt.Print(help())
+		fmt.Fprintf(os.Stderr, "fatal: expected 1 argument, got %d\n", flag.NArg()-1)
+		os.Exit(1)
+	}
+	locks, err := internal.ReapLocks(flag.Arg(1), opts)
+	for _, lock := range locks {
+		fmt.Printf("reaped lock %s on %s, owned by %s\n", lock.ID, lock.Path, lock.Owner)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func poolObjects(opts internal.Options) {
+	if flag.NArg() != 2 {
+		fmt.Print(help())
+		fmt.Fprintf(os.Stderr, "fatal: expected 1 argument, got %d\n", flag.NArg()-1)
+		os.Exit(1)
+	}
+	n, err := internal.PoolObjects(flag.Arg(1), opts)
+	fmt.Printf("moved %d objects into the pool\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func hook(opts internal.Options) {
+	if flag.NArg() != 2 || flag.Arg(1) != "pre-receive" {
+		fmt.Print(help())
+		fmt.Fprintf(os.Stderr, "fatal: unknown hook\n")
+		os.Exit(1)
+	}
+	// git runs hooks in the repository, with GIT_DIR set
+	err := internal.PreReceive(os.Stdin, os.Stderr, "", opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func lockLog(opts internal.Options) {
+	var filter internal.LockLogFilter
+	var since string
+	fs := flag.NewFlagSet("lock-log", flag.ExitOnError)
+	fs.Usage = flag.Usage
+	fs.StringVar(&filter.Path, "path", "", "")
+	fs.StringVar(&filter.ID, "id", "", "")
+	fs.StringVar(&filter.User, "user", "", "")
+	fs.StringVar(&since, "since", "", "")
+	fs.Parse(flag.Args()[1:])
+	if fs.NArg() != 1 {
+		fmt.Print(help())
+		fmt.Fprintf(os.Stderr, "fatal: expected 1 argument, got %d\n", fs.NArg())
+		os.Exit(1)
+	}
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: invalid time %q, expected RFC 3339\n", since)
+			os.Exit(1)
+		}
+		filter.Since = t
+	}
+
+	events, err := internal.ReadLockLog(fs.Arg(0), opts, filter)
+	for _, e := range events {
+		line := fmt.Sprintf("%s %s %s id=%s owner=%s", e.Time, e.Action, e.Path, e.ID, e.Owner)
+		if e.User != "" {
+			line += " user=" + e.User
+		}
+		if e.Force {
+			line += " force"
+		}
+		if e.Client != "" {
+			line += " client=" + e.Client
+		}
+		fmt.Println(line)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func help() string {
 	return `git-lfs-transfer - Server-side implementation of Git LFS over SSH
 
-usage: git-lfs-transfer <git-dir> <operation>
+usage: git-lfs-transfer [--read-only] <git-dir> <operation>
+   or: git-lfs-transfer reap-locks <git-dir>
+   or: git-lfs-transfer lock-log [--path <path>] [--id <id>] [--user <user>]
+                                 [--since <time>] <git-dir>
+   or: git-lfs-transfer hook pre-receive
+   or: git-lfs-transfer pool-objects <git-dir>
+
+  --read-only  only serve downloads
+
+When run without arguments as an SSH forced command, the command line is
+read from SSH_ORIGINAL_COMMAND.
+
+reap-locks removes the expired locks of a repository and lists them.
+
+lock-log prints the audit log of lock changes, optionally only those of a
+path, a lock ID, a user (as the lock owner or the one making the change) or
+since an RFC 3339 time.
+
+hook pre-receive refuses pushes changing files locked by other users, when
+run as the pre-receive hook of a repository.
+
+pool-objects moves the objects of a repository into its object pool.
 
 `
 }
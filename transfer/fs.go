@@ -0,0 +1,480 @@
+package transfer
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/git-lfs/git-lfs/v3/tools"
+)
+
+// filesystem runs the commands of a session.
+type filesystem struct {
+	c     *PktlineChannel
+	path  string
+	store Storage
+	locks LockStore
+	id    *Identity
+	perms Permission
+	// refLocks scopes locks taken with a refname to that ref.
+	refLocks bool
+	lockTTL  time.Duration
+	now      func() time.Time
+}
+
+var (
+	errConflict        = errors.New("conflict")
+	errNotLockOwner    = errors.New("lock is owned by another user")
+	errForceNotAllowed = errors.New("forcing an unlock requires admin permission")
+)
+
+// ValidOid reports whether oid is a SHA-256 object ID. Storage is only
+// handed valid object IDs.
+func ValidOid(oid string) bool {
+	if len(oid) != 64 {
+		return false
+	}
+	for _, c := range oid {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// commandOid returns the object ID the command of the request is run on.
+func (fs *filesystem) commandOid() (string, error) {
+	_, oid, _ := strings.Cut(fs.c.req.args[0], " ")
+	if !ValidOid(oid) {
+		return "", fmt.Errorf("invalid object ID %q", oid)
+	}
+	return oid, nil
+}
+
+func (fs *filesystem) lockObject() ([]string, error) {
+	var file, refname string
+	for _, arg := range fs.c.req.args {
+		if strings.HasPrefix(arg, "path=") {
+			file = arg[5:]
+		}
+		if strings.HasPrefix(arg, "refname=") {
+			refname = arg[8:]
+		}
+	}
+	if file == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	if !fs.refLocks {
+		// the lock is on all refs, see LockFilter
+		refname = ""
+	}
+
+	id := LockID(file, refname, fs.refLocks)
+	now := fs.now().UTC()
+
+	lock := &Lock{ID: id, Path: file, Owner: fs.id.User, LockedAt: now.Format(time.RFC3339), Refname: refname}
+	if fs.lockTTL > 0 {
+		lock.ExpiresAt = now.Add(fs.lockTTL).Format(time.RFC3339)
+	}
+
+	log, err := openLockLog(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	// expired locks in the way are replaced, each conflict removes one
+	for {
+		err := fs.locks.Create(lock)
+		if err == nil {
+			break
+		}
+		var conflict *LockConflictError
+		if !errors.As(err, &conflict) {
+			return nil, err
+		}
+		expired, err := ExpireLock(fs.locks, fs.path, conflict.Lock.ID, now, fs.lockTTL, fs.id)
+		if err != nil {
+			return nil, err
+		}
+		if expired != nil {
+			continue
+		}
+		if _, err := fs.locks.Get(conflict.Lock.ID); errors.Is(err, os.ErrNotExist) {
+			// unlocked by somebody else in the meantime
+			continue
+		}
+
+		existing := conflict.Lock
+		msgs := []string{
+			fmt.Sprintf("id=%s", existing.ID),
+			fmt.Sprintf("path=%s", existing.Path),
+			fmt.Sprintf("locked-at=%s", existing.LockedAt),
+			fmt.Sprintf("ownername=%s", existing.Owner),
+		}
+
+		return msgs, errConflict
+	}
+
+	if err := writeLockEvent(log, newLockEvent("lock", lock, id, fs.id, now)); err != nil {
+		// undo the change, unless somebody else replaced the lock since
+		fs.locks.Delete(id, func(l *Lock) bool { return l.Owner == lock.Owner && l.LockedAt == lock.LockedAt })
+		return nil, err
+	}
+
+	msgs := []string{
+		fmt.Sprintf("id=%s", id),
+		fmt.Sprintf("path=%s", file),
+		fmt.Sprintf("locked-at=%s", lock.LockedAt),
+		fmt.Sprintf("ownername=%s", fs.id.User),
+	}
+
+	return msgs, nil
+}
+
+// listLocks returns the locks matching the path, id, owner and refspec arguments,
+// ordered by id. At most limit locks are returned per call; if there are
+// more, the returned arguments hold the cursor to continue from.
+func (fs *filesystem) listLocks() ([]string, []string, error) {
+	var path, id, owner, refspec, cursor string
+	var limit int
+	var err error
+	for _, arg := range fs.c.req.args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "path="):
+			path = arg[5:]
+		case strings.HasPrefix(arg, "id="):
+			id = arg[3:]
+		case strings.HasPrefix(arg, "owner="):
+			owner = arg[6:]
+		case strings.HasPrefix(arg, "refspec="):
+			refspec = arg[8:]
+		case strings.HasPrefix(arg, "cursor="):
+			cursor = arg[7:]
+		case strings.HasPrefix(arg, "limit="):
+			limit, err = strconv.Atoi(arg[6:])
+			if err != nil || limit < 0 {
+				return nil, nil, fmt.Errorf("invalid limit %q", arg[6:])
+			}
+		}
+	}
+
+	args := []string{}
+	msgs := []string{}
+	count := 0
+	now := fs.now()
+
+	filter := LockFilter{ID: id, Path: path, Owner: owner, Cursor: cursor}
+	if fs.refLocks {
+		filter.Refname = refspec
+	}
+	err = fs.locks.List(filter, func(lock *Lock) error {
+		if lock.Expired(now, fs.lockTTL) {
+			return nil
+		}
+		if limit > 0 && count == limit {
+			args = append(args, fmt.Sprintf("next-cursor=%s", lock.ID))
+			return ErrStopLocks
+		}
+		count++
+
+		msgs = append(msgs, fmt.Sprintf("lock %s", lock.ID))
+		msgs = append(msgs, fmt.Sprintf("path %s %s", lock.ID, lock.Path))
+		msgs = append(msgs, fmt.Sprintf("locked-at %s %s", lock.ID, lock.LockedAt))
+		msgs = append(msgs, fmt.Sprintf("ownername %s %s", lock.ID, lock.Owner))
+
+		if lock.Owner == fs.id.User {
+			msgs = append(msgs, fmt.Sprintf("owner %s %s", lock.ID, "ours"))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("owner %s %s", lock.ID, "theirs"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return args, msgs, nil
+}
+
+func (fs *filesystem) unlockObject() ([]string, error) {
+	_, id, _ := strings.Cut(fs.c.req.args[0], " ")
+	force := false
+	for _, arg := range fs.c.req.args[1:] {
+		if arg == "force=true" {
+			force = true
+		}
+	}
+
+	log, err := openLockLog(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	var denied error
+	lock, err := fs.locks.Delete(id, func(lock *Lock) bool {
+		if lock.Owner != fs.id.User {
+			if !force {
+				denied = errNotLockOwner
+			} else if fs.perms&PermAdmin == 0 {
+				denied = errForceNotAllowed
+			}
+		}
+		return denied == nil
+	})
+	if denied != nil {
+		return nil, denied
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("lock does not exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event := newLockEvent("unlock", lock, id, fs.id, fs.now())
+	event.Force = lock.Owner != fs.id.User
+	if err := writeLockEvent(log, event); err != nil {
+		fs.locks.Create(lock)
+		return nil, err
+	}
+
+	msgs := []string{
+		fmt.Sprintf("id=%s", id),
+		fmt.Sprintf("path=%s", lock.Path),
+		fmt.Sprintf("locked-at=%s", lock.LockedAt),
+		fmt.Sprintf("ownername=%s", lock.Owner),
+	}
+
+	return msgs, nil
+}
+
+func (fs *filesystem) getObject() error {
+	oid, err := fs.commandOid()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for _, arg := range fs.c.req.args[1:] {
+		if strings.HasPrefix(arg, "offset=") {
+			offset, err = strconv.ParseInt(arg[7:], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	size, err := fs.store.Stat(oid)
+	if err != nil {
+		return fmt.Errorf("not found")
+	}
+	if offset < 0 || offset > size {
+		return fmt.Errorf("offset %d out of range for %d bytes", offset, size)
+	}
+	f := io.NopCloser(strings.NewReader(""))
+	if offset < size {
+		f, err = fs.store.Open(oid, offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	// size is what is left to send, so a resumed download is complete once
+	// it has received size bytes
+	fs.c.pl.WritePacketText("status 200")
+	fs.c.pl.WritePacketText(fmt.Sprintf("size=%v", size-offset))
+	fs.c.pl.WriteDelim()
+
+	defer f.Close()
+	buf := make([]byte, 32768)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			err := fs.c.pl.WritePacket(buf[0:n])
+			if err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	fs.c.pl.WriteFlush()
+	return nil
+}
+
+func (fs *filesystem) storeObject() (err error) {
+	oid, err := fs.commandOid()
+	if err != nil {
+		return err
+	}
+	var size, offset int64
+	for _, arg := range fs.c.req.args[1:] {
+		if strings.HasPrefix(arg, "size=") {
+			size, err = strconv.ParseInt(arg[5:], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(arg, "offset=") {
+			offset, err = strconv.ParseInt(arg[7:], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if size < 0 || offset < 0 || offset > size {
+		return fmt.Errorf("offset %d out of range for %d bytes", offset, size)
+	}
+	if existing, err := fs.store.Stat(oid); err == nil && existing == size {
+		// file already exists, nothing to do
+		io.Copy(io.Discard, fs.c.req.data)
+		return nil
+	}
+
+	// partial uploads are kept in incomplete/ so that the client can resume
+	// them from the offset advertised by batch
+	partialPath := filepath.Join(fs.path, "incomplete", oid)
+	partial, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer partial.Close()
+	if err := syscall.Flock(int(partial.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return fmt.Errorf("upload of %s already in progress", oid)
+	}
+	defer func() {
+		// an upload that failed before any data arrived leaves nothing to
+		// resume
+		if fi, statErr := partial.Stat(); err != nil && statErr == nil && fi.Size() == 0 {
+			os.Remove(partialPath)
+		}
+	}()
+	fi, err := partial.Stat()
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset > fi.Size() {
+		return fmt.Errorf("cannot resume upload at offset %d, %d bytes present", offset, fi.Size())
+	}
+	if err := partial.Truncate(offset); err != nil {
+		return err
+	}
+
+	hasher := tools.NewLfsContentHash()
+	if _, err := io.CopyN(hasher, partial, offset); err != nil {
+		return err
+	}
+
+	type ProgressCallback func(name string, totalSize, readSoFar int64, readSinceLast int) error
+	var cb ProgressCallback
+	ccb := func(totalSize int64, readSoFar int64, readSinceLast int) error {
+		if cb != nil {
+			return cb(oid, totalSize, readSoFar, readSinceLast)
+		}
+		return nil
+	}
+
+	written, err := tools.CopyWithCallback(partial, io.TeeReader(fs.c.req.data, hasher), size-offset, ccb)
+	written += offset
+	if err != nil {
+		return fmt.Errorf("upload interrupted after %d bytes: %s", written, err)
+	}
+	if written < size {
+		return fmt.Errorf("upload incomplete, %d of %d bytes received", written, size)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != oid {
+		os.Remove(partialPath)
+		return fmt.Errorf("expected OID %s, got %s after %d bytes written", oid, actual, written)
+	}
+	if size != written {
+		os.Remove(partialPath)
+		return fmt.Errorf("can not verify file size after upload")
+	}
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	return importObject(fs.store, oid, partialPath)
+}
+
+func (fs *filesystem) verifyObject() error {
+	oid, err := fs.commandOid()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, arg := range fs.c.req.args[1:] {
+		if strings.HasPrefix(arg, "size=") {
+			size, err = strconv.ParseInt(arg[5:], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	actual, err := fs.store.Stat(oid)
+	if err != nil {
+		return fmt.Errorf("not found")
+	}
+	if size != actual {
+		return fmt.Errorf("can not verify file size after upload")
+	}
+	return nil
+}
+
+func (fs *filesystem) batchObjects(cmdIn string) ([]string, error) {
+	files := []string{}
+	for _, line := range fs.c.req.lines {
+		fields := strings.Split(line, " ")
+		if len(fields) < 2 || !ValidOid(fields[0]) {
+			return nil, fmt.Errorf("invalid object %q", line)
+		}
+		oid := fields[0]
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid object %q", line)
+		}
+		cmdOut := cmdIn
+		if cmdIn == "download" {
+			actual, err := fs.store.Stat(oid)
+			if err != nil {
+				cmdOut = "noop"
+			} else {
+				if size != actual {
+					cmdOut = "noop"
+				}
+			}
+		}
+		if cmdIn == "upload" {
+			if partial := fs.partialSize(line); partial > 0 {
+				cmdOut += fmt.Sprintf(" offset=%d", partial)
+			}
+		}
+		files = append(files, fmt.Sprintf("%s %s", line, cmdOut))
+	}
+	return files, nil
+}
+
+// partialSize returns how much of the object in a batch line has been
+// received by an interrupted upload.
+func (fs *filesystem) partialSize(line string) int64 {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 {
+		return 0
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	fi, err := os.Stat(filepath.Join(fs.path, "incomplete", fields[0]))
+	if err != nil || fi.Size() >= size {
+		return 0
+	}
+	return fi.Size()
+}
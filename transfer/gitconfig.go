@@ -0,0 +1,138 @@
+package transfer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type gitConfig struct {
+	values map[string][]string
+}
+
+func readGitConfig(path string) (*gitConfig, error) {
+	cfg := &gitConfig{values: map[string][]string{}}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var section string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("bad config line %d in %s", n, path)
+			}
+			section = parseSectionHeader(line[1:end])
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			return nil, fmt.Errorf("bad config line %d in %s", n, path)
+		}
+		key, value := line, "true"
+		if i := strings.Index(line, "="); i >= 0 {
+			key = strings.TrimSpace(line[:i])
+			value = parseConfigValue(line[i+1:])
+		}
+		name := section + "." + strings.ToLower(key)
+		cfg.values[name] = append(cfg.values[name], value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseSectionHeader turns `core` or `remote "origin"` into `core` or
+// `remote.origin`; section names are case-insensitive, subsections are not.
+func parseSectionHeader(s string) string {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return strings.ToLower(s)
+	}
+	name := strings.ToLower(s[:i])
+	sub := strings.TrimSpace(s[i:])
+	sub = strings.TrimSuffix(strings.TrimPrefix(sub, `"`), `"`)
+	sub = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(sub)
+	return name + "." + sub
+}
+
+func parseConfigValue(s string) string {
+	var b strings.Builder
+	quoted := false
+	s = strings.TrimSpace(s)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Get returns the last value set for key, as git does.
+func (c *gitConfig) Get(key string) (string, bool) {
+	vals := c.values[normalizeConfigKey(key)]
+	if len(vals) == 0 {
+		return "", false
+	}
+	return vals[len(vals)-1], true
+}
+
+func (c *gitConfig) Bool(key string, def bool) bool {
+	v, ok := c.Get(key)
+	if !ok {
+		return def
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return n != 0
+	}
+	return def
+}
+
+func normalizeConfigKey(key string) string {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
@@ -0,0 +1,140 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEndSession is returned by a Handler to end the session without an
+// error once its response has been sent.
+var ErrEndSession = errors.New("end of session")
+
+// Handler runs a command and writes the response to the session channel.
+// Returning an error other than ErrEndSession aborts the session.
+type Handler func(sess *Session, req *ChannelRequest) error
+
+// Session is the state of a running session handed to handlers.
+type Session struct {
+	Server    *Server
+	Channel   *PktlineChannel
+	Operation string
+
+	fs *filesystem
+}
+
+var defaultHandlers = map[string]Handler{
+	"quit":          handleQuit,
+	"version":       handleVersion,
+	"batch":         handleBatch,
+	"get-object":    handleGetObject,
+	"put-object":    handlePutObject,
+	"verify-object": handleVerifyObject,
+	"lock":          handleLock,
+	"unlock":        handleUnlock,
+	"list-lock":     handleListLocks,
+	"list-locks":    handleListLocks,
+}
+
+// Handle registers h for the command verb, replacing the built-in handler if
+// there is one. Handlers for new commands are responsible for their own
+// permission checks.
+func (s *Server) Handle(verb string, h Handler) {
+	if s.handlers == nil {
+		s.handlers = make(map[string]Handler)
+	}
+	s.handlers[verb] = h
+}
+
+func (s *Server) handler(verb string) Handler {
+	if h, ok := s.handlers[verb]; ok {
+		return h
+	}
+	return defaultHandlers[verb]
+}
+
+// endSession sends a final response and ends the session.
+func endSession(c *PktlineChannel, args []string, msgs []string) error {
+	if err := c.SendMessage(args, msgs); err != nil {
+		return err
+	}
+	return ErrEndSession
+}
+
+func handleQuit(sess *Session, req *ChannelRequest) error {
+	if err := sess.Channel.End(); err != nil {
+		return err
+	}
+	return ErrEndSession
+}
+
+func handleVersion(sess *Session, req *ChannelRequest) error {
+	if req.args[0] != "version 1" {
+		return sess.Channel.SendMessage([]string{"status 400"}, []string{"unsupported version"})
+	}
+	return sess.Channel.SendMessage([]string{"status 200"}, nil)
+}
+
+func handleBatch(sess *Session, req *ChannelRequest) error {
+	files, err := sess.fs.batchObjects(sess.Operation)
+	if err != nil {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return sess.Channel.SendMessage([]string{"status 200", "hash-algo=sha256"}, files)
+}
+
+func handleGetObject(sess *Session, req *ChannelRequest) error {
+	if err := sess.fs.getObject(); err != nil {
+		return sess.Channel.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return nil
+}
+
+func handlePutObject(sess *Session, req *ChannelRequest) error {
+	if err := sess.fs.storeObject(); err != nil {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return sess.Channel.SendMessage([]string{"status 200"}, nil)
+}
+
+func handleVerifyObject(sess *Session, req *ChannelRequest) error {
+	if err := sess.fs.verifyObject(); err != nil {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return sess.Channel.SendMessage([]string{"status 200"}, nil)
+}
+
+func handleLock(sess *Session, req *ChannelRequest) error {
+	msgs, err := sess.fs.lockObject()
+	if errors.Is(err, errLockLog) {
+		return endSession(sess.Channel, []string{"status 500"}, []string{errLockLog.Error()})
+	}
+	if err != nil && !errors.Is(err, errConflict) {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	if err != nil {
+		return endSession(sess.Channel, []string{"status 409"}, append(msgs, fmt.Sprintf("%s", err)))
+	}
+	return sess.Channel.SendMessage(append([]string{"status 201"}, msgs...), nil)
+}
+
+func handleUnlock(sess *Session, req *ChannelRequest) error {
+	msgs, err := sess.fs.unlockObject()
+	if errors.Is(err, errNotLockOwner) || errors.Is(err, errForceNotAllowed) {
+		return endSession(sess.Channel, []string{"status 403"}, []string{fmt.Sprintf("%s", err)})
+	}
+	if errors.Is(err, errLockLog) {
+		return endSession(sess.Channel, []string{"status 500"}, []string{errLockLog.Error()})
+	}
+	if err != nil {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return sess.Channel.SendMessage(append([]string{"status 200"}, msgs...), nil)
+}
+
+func handleListLocks(sess *Session, req *ChannelRequest) error {
+	args, msgs, err := sess.fs.listLocks()
+	if err != nil {
+		return endSession(sess.Channel, []string{"status 400"}, []string{fmt.Sprintf("%s", err)})
+	}
+	return sess.Channel.SendMessage(append([]string{"status 202"}, args...), msgs)
+}
@@ -0,0 +1,12 @@
+package transfer
+
+// Identity is the user a session is served for. User owns the locks the
+// session takes; Groups and Key are what access policies match besides the
+// user.
+type Identity struct {
+	User   string
+	Groups []string
+	Key    string
+	// Client is the address the SSH client connected from, if known.
+	Client string
+}
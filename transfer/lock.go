@@ -0,0 +1,155 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFormatVersion = 1
+
+// Lock is the record stored for each lock in locks/<id>, as JSON:
+//
+//	{
+//	  "version": 1,
+//	  "id": "c7b8de23...",
+//	  "path": "assets/level1.psd",
+//	  "owner": "alice",
+//	  "locked-at": "2023-04-01T12:00:00Z",
+//	  "refname": "refs/heads/main",
+//	  "expires-at": "2023-05-01T12:00:00Z"
+//	}
+//
+// Older versions of the server wrote gob encoded maps, which are converted
+// when they are first read.
+type Lock struct {
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	Path      string `json:"path"`
+	Owner     string `json:"owner"`
+	LockedAt  string `json:"locked-at"`
+	Refname   string `json:"refname,omitempty"`
+	ExpiresAt string `json:"expires-at,omitempty"`
+}
+
+// Expired reports whether the lock has expired at now. Locks recorded
+// without an expiry time expire ttl after they were taken, if ttl is set.
+func (l *Lock) Expired(now time.Time, ttl time.Duration) bool {
+	at, err := time.Parse(time.RFC3339, l.ExpiresAt)
+	if l.ExpiresAt == "" {
+		if ttl <= 0 {
+			return false
+		}
+		at, err = time.Parse(time.RFC3339, l.LockedAt)
+		at = at.Add(ttl)
+	}
+	return err == nil && !now.Before(at)
+}
+
+func encodeLock(lock *Lock) ([]byte, error) {
+	lock.Version = lockFormatVersion
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func readLockFile(path string) (*Lock, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) > 0 && bytes.TrimSpace(b)[0] == '{' {
+		lock := &Lock{}
+		if err := json.Unmarshal(b, lock); err != nil {
+			return nil, fmt.Errorf("invalid lock %s: %s", filepath.Base(path), err)
+		}
+		if lock.Version > lockFormatVersion {
+			return nil, fmt.Errorf("unsupported version %d of lock %s", lock.Version, filepath.Base(path))
+		}
+		return lock, nil
+	}
+	return migrateGobLock(path, b)
+}
+
+// migrateGobLock rewrites a lock written by earlier versions as JSON.
+func migrateGobLock(path string, b []byte) (*Lock, error) {
+	var decodedMap map[string]string
+	d := gob.NewDecoder(bytes.NewReader(b))
+	err := d.Decode(&decodedMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock %s: %s", filepath.Base(path), err)
+	}
+	lock := &Lock{
+		ID:       filepath.Base(path),
+		Path:     decodedMap["path"],
+		Owner:    decodedMap["ownername"],
+		LockedAt: decodedMap["locked-at"],
+	}
+
+	data, err := encodeLock(lock)
+	if err != nil {
+		return nil, err
+	}
+	// staged in the tmp directory next to locks, so that it is never listed
+	tmp, err := os.CreateTemp(filepath.Join(filepath.Dir(path), "..", "tmp"), "lock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	return lock, os.Rename(tmp.Name(), path)
+}
+
+// LockID returns the ID of the lock on path. Locks are global unless
+// ref-scoped locking is enabled and the client names the ref.
+func LockID(path string, refname string, refLocks bool) string {
+	hash := sha256.New()
+	if refLocks && refname != "" {
+		hash.Write([]byte(refname + "\x00"))
+	}
+	hash.Write([]byte(path))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Conflicts reports whether lock cannot be taken while existing is held: it
+// has the same ID, or it is on the same path and one of them is a lock on all
+// refs. A lock on all refs does not conflict with the locks of its own owner
+// on single refs.
+func (l *Lock) Conflicts(existing *Lock) bool {
+	if l.ID == existing.ID {
+		return true
+	}
+	if l.Path != existing.Path {
+		return false
+	}
+	if existing.global() {
+		return true
+	}
+	return l.global() && l.Owner != existing.Owner
+}
+
+// global reports whether the lock applies to all refs.
+func (l *Lock) global() bool {
+	return l.ID == LockID(l.Path, "", false)
+}
+
+// Covers reports whether the lock applies to refname. Locks on all refs
+// apply to every ref.
+func (l *Lock) Covers(refname string, refLocks bool) bool {
+	return !refLocks || refname == "" || l.Refname == refname || l.ID == LockID(l.Path, "", refLocks)
+}
@@ -0,0 +1,39 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadLockFileVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	if err := os.WriteFile(path, []byte(`{"version": 2, "id": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readLockFile(path); err == nil {
+		t.Errorf("expected error for unknown lock version")
+	}
+}
+
+func TestLockExpired(t *testing.T) {
+	now := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lock     Lock
+		ttl      time.Duration
+		expected bool
+	}{
+		{Lock{LockedAt: "2023-01-01T12:00:00Z"}, 0, false},
+		{Lock{LockedAt: "2023-01-01T12:00:00Z"}, time.Hour, true},
+		{Lock{LockedAt: "2023-01-01T12:00:00Z"}, 48 * time.Hour, false},
+		{Lock{LockedAt: "2023-01-01T12:00:00Z", ExpiresAt: "2023-01-02T11:00:00Z"}, 0, true},
+		{Lock{LockedAt: "2023-01-01T12:00:00Z", ExpiresAt: "2023-01-03T12:00:00Z"}, time.Hour, false},
+		{Lock{LockedAt: "yesterday"}, time.Hour, false},
+	}
+	for _, tt := range tests {
+		if got := tt.lock.Expired(now, tt.ttl); got != tt.expected {
+			t.Errorf("%+v with ttl %s: got %v, want %v", tt.lock, tt.ttl, got, tt.expected)
+		}
+	}
+}
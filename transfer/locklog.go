@@ -0,0 +1,63 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockEvent is a line of the audit log in logs/locks.log. Action is one of
+// lock, unlock or expire. User and Client describe who made the change and
+// from where; they are empty for locks removed by reap-locks.
+type LockEvent struct {
+	Time   string `json:"time"`
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	Owner  string `json:"owner"`
+	User   string `json:"user,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+	Client string `json:"client,omitempty"`
+}
+
+// newLockEvent returns the event for action on lock, made by id at now.
+func newLockEvent(action string, lock *Lock, lockID string, id *Identity, now time.Time) LockEvent {
+	event := LockEvent{Time: now.UTC().Format(time.RFC3339), Action: action, ID: lockID, Path: lock.Path, Owner: lock.Owner}
+	if id != nil {
+		event.User = id.User
+		event.Client = id.Client
+	}
+	return event
+}
+
+// errLockLog is returned for lock changes that are refused because they
+// cannot be recorded in the lock log.
+var errLockLog = errors.New("cannot write lock log")
+
+// openLockLog opens the lock log for appending. Lock changes open it before
+// they are made, so that a change is only made if it can be recorded.
+func openLockLog(lfsPath string) (*os.File, error) {
+	dir := filepath.Join(lfsPath, "logs")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("%w: %s", errLockLog, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "locks.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errLockLog, err)
+	}
+	return f, nil
+}
+
+func writeLockEvent(f *os.File, event LockEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("%w: %s", errLockLog, err)
+	}
+	return nil
+}
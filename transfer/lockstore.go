@@ -0,0 +1,268 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrStopLocks ends LockStore.List early without failing it.
+var ErrStopLocks = errors.New("stop listing locks")
+
+// LockStore holds the locks of a repository. Methods report missing locks
+// with errors matching os.ErrNotExist.
+type LockStore interface {
+	// Create adds lock, failing with a *LockConflictError if it conflicts
+	// with an existing lock, see Conflicts. The check and the creation are
+	// atomic.
+	Create(lock *Lock) error
+	Get(id string) (*Lock, error)
+	// Delete removes the lock with id if cond, given the lock, returns true
+	// or is nil. It returns the lock it removed. Deletes do not interleave,
+	// so cond always sees the lock that would be removed.
+	Delete(id string, cond func(lock *Lock) bool) (*Lock, error)
+	// List calls fn with each lock matching filter, ordered by ID. If fn
+	// returns ErrStopLocks, List stops and returns nil.
+	List(filter LockFilter, fn func(lock *Lock) error) error
+	Close() error
+}
+
+// LockConflictError is returned by LockStore.Create for a lock conflicting
+// with Lock. It matches os.ErrExist.
+type LockConflictError struct {
+	Lock *Lock
+}
+
+func (e *LockConflictError) Error() string {
+	return fmt.Sprintf("lock %s: %s", e.Lock.ID, os.ErrExist)
+}
+
+func (e *LockConflictError) Unwrap() error {
+	return os.ErrExist
+}
+
+// LockFilter selects locks by their fields. Empty fields match any lock,
+// Refname matches the locks on that ref and those without a ref, and Cursor
+// skips the locks with smaller IDs.
+type LockFilter struct {
+	ID      string
+	Path    string
+	Owner   string
+	Refname string
+	Cursor  string
+}
+
+func (f LockFilter) match(lock *Lock) bool {
+	return (f.ID == "" || lock.ID == f.ID) &&
+		(f.Path == "" || lock.Path == f.Path) &&
+		(f.Owner == "" || lock.Owner == f.Owner) &&
+		(f.Refname == "" || lock.Refname == "" || lock.Refname == f.Refname) &&
+		lock.ID >= f.Cursor
+}
+
+// ExpireLock removes the lock with lockID from store if it has expired at
+// now, and records the removal on behalf of id, which is nil for
+// maintenance. It returns the removed lock, or nil if the lock is still
+// valid or gone. A lock whose removal cannot be recorded is kept.
+func ExpireLock(store LockStore, lfsPath string, lockID string, now time.Time, ttl time.Duration, id *Identity) (*Lock, error) {
+	log, err := openLockLog(lfsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	lock, err := store.Delete(lockID, func(lock *Lock) bool { return lock.Expired(now, ttl) })
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	if err := writeLockEvent(log, newLockEvent("expire", lock, lockID, id, now)); err != nil {
+		store.Create(lock)
+		return nil, err
+	}
+	return lock, nil
+}
+
+// FileLockStore keeps every lock as a JSON file in locks/<id>, see Lock.
+type FileLockStore struct {
+	dir string
+	tmp string
+	// mu is the file serialising creates and deletes
+	mu string
+}
+
+func NewFileLockStore(lfsPath string) *FileLockStore {
+	return &FileLockStore{
+		dir: filepath.Join(lfsPath, "locks"),
+		tmp: filepath.Join(lfsPath, "tmp"),
+		mu:  filepath.Join(lfsPath, "locks.lock"),
+	}
+}
+
+// file returns the file of the lock id. IDs come from clients, so those
+// that are not plain file names are reported as not existing.
+func (s *FileLockStore) file(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/\\\x00") {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+func (s *FileLockStore) Create(lock *Lock) error {
+	path, err := s.file(lock.ID)
+	if err != nil {
+		return fmt.Errorf("invalid lock ID %q", lock.ID)
+	}
+	b, err := encodeLock(lock)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = s.List(LockFilter{Path: lock.Path}, func(existing *Lock) error {
+		if lock.Conflicts(existing) {
+			return &LockConflictError{Lock: existing}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = createExclusive(path, s.tmp, b)
+	if errors.Is(err, os.ErrExist) {
+		// a lock with the ID but another path, edited by hand
+		existing, getErr := s.Get(lock.ID)
+		if getErr != nil {
+			return err
+		}
+		return &LockConflictError{Lock: existing}
+	}
+	return err
+}
+
+func (s *FileLockStore) Get(id string) (*Lock, error) {
+	path, err := s.file(id)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := readLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// the file name is authoritative, the lock may have been edited by hand
+	lock.ID = id
+	return lock, nil
+}
+
+func (s *FileLockStore) Delete(id string, cond func(lock *Lock) bool) (*Lock, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	lock, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if cond != nil && !cond(lock) {
+		return nil, nil
+	}
+	return lock, os.Remove(filepath.Join(s.dir, lock.ID))
+}
+
+func (s *FileLockStore) List(filter LockFilter, fn func(lock *Lock) error) error {
+	var names []string
+	if filter.ID != "" {
+		names = []string{filter.ID}
+	} else {
+		files, err := os.ReadDir(s.dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		if name < filter.Cursor {
+			continue
+		}
+		lock, err := s.Get(name)
+		if os.IsNotExist(err) {
+			// removed while listing
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !filter.match(lock) {
+			continue
+		}
+		if err := fn(lock); err != nil {
+			if err == ErrStopLocks {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileLockStore) Close() error {
+	return nil
+}
+
+// lock takes the file lock serialising changes to the store, and returns the
+// function releasing it.
+func (s *FileLockStore) lock() (func(), error) {
+	f, err := os.OpenFile(s.mu, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// createExclusive writes data to path unless path already exists. The file
+// is written in full before it is linked into place, and linking fails if
+// the target exists, so of several sessions creating the same file exactly
+// one succeeds and nobody sees it half-written.
+func createExclusive(path string, tmpDir string, data []byte) error {
+	tmp, err := os.CreateTemp(tmpDir, "lock")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), path)
+}
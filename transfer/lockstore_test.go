@@ -0,0 +1,146 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testLockStore(t *testing.T, store LockStore) {
+	t.Helper()
+
+	if _, err := store.Get("a"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing lock, got %v", err)
+	}
+
+	locks := []*Lock{
+		{ID: "c", Path: "c.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"},
+		{ID: "a", Path: "a.bin", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z", Refname: "refs/heads/main"},
+		{ID: "b", Path: "b.bin", Owner: "bob", LockedAt: "2023-01-02T03:04:05Z", ExpiresAt: "2023-02-02T03:04:05Z"},
+	}
+	for _, lock := range locks {
+		if err := store.Create(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Create(&Lock{ID: "a", Path: "other.bin", Owner: "bob"}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected existing lock, got %v", err)
+	}
+
+	lock, err := store.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lock != (Lock{Version: 1, ID: "b", Path: "b.bin", Owner: "bob", LockedAt: "2023-01-02T03:04:05Z", ExpiresAt: "2023-02-02T03:04:05Z"}) {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+
+	tests := []struct {
+		filter   LockFilter
+		expected string
+	}{
+		{LockFilter{}, "abc"},
+		{LockFilter{ID: "b"}, "b"},
+		{LockFilter{ID: "d"}, ""},
+		{LockFilter{Path: "c.bin"}, "c"},
+		{LockFilter{Owner: "alice"}, "ac"},
+		{LockFilter{Owner: "alice", Cursor: "b"}, "c"},
+		{LockFilter{Cursor: "b"}, "bc"},
+		{LockFilter{Refname: "refs/heads/main"}, "abc"},
+		{LockFilter{Refname: "refs/heads/dev"}, "bc"},
+	}
+	for _, tt := range tests {
+		ids := ""
+		err := store.List(tt.filter, func(lock *Lock) error {
+			ids += lock.ID
+			return nil
+		})
+		if err != nil || ids != tt.expected {
+			t.Errorf("%+v: got %q (%v), want %q", tt.filter, ids, err, tt.expected)
+		}
+	}
+
+	ids := ""
+	err = store.List(LockFilter{}, func(lock *Lock) error {
+		ids += lock.ID
+		return ErrStopLocks
+	})
+	if err != nil || ids != "a" {
+		t.Errorf("listing was not stopped: got %q (%v)", ids, err)
+	}
+
+	lock, err = store.Delete("a", func(lock *Lock) bool { return lock.Owner == "bob" })
+	if err != nil || lock != nil {
+		t.Fatalf("lock was deleted: %+v (%v)", lock, err)
+	}
+	lock, err = store.Delete("a", func(lock *Lock) bool { return lock.Owner == "alice" })
+	if err != nil || lock == nil || lock.Path != "a.bin" {
+		t.Fatalf("lock was not deleted: %+v (%v)", lock, err)
+	}
+	if _, err := store.Delete("a", nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing lock, got %v", err)
+	}
+
+	// only one of several concurrent deletes removes the lock
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deleted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := store.Delete("b", nil)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Error(err)
+			}
+			if lock != nil {
+				mu.Lock()
+				deleted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if deleted != 1 {
+		t.Errorf("lock deleted %d times", deleted)
+	}
+
+	// a lock on all refs conflicts with the locks of others on single refs
+	onRef := &Lock{ID: LockID("d.bin", "refs/heads/main", true), Path: "d.bin", Owner: "bob", Refname: "refs/heads/main"}
+	if err := store.Create(onRef); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *LockConflictError
+	err = store.Create(&Lock{ID: LockID("d.bin", "", true), Path: "d.bin", Owner: "alice"})
+	if !errors.As(err, &conflict) || conflict.Lock.ID != onRef.ID {
+		t.Fatalf("expected a conflict with %s, got %v", onRef.ID, err)
+	}
+	if err := store.Create(&Lock{ID: LockID("d.bin", "", true), Path: "d.bin", Owner: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	err = store.Create(&Lock{ID: LockID("d.bin", "refs/heads/dev", true), Path: "d.bin", Owner: "bob", Refname: "refs/heads/dev"})
+	if !errors.As(err, &conflict) || conflict.Lock.ID != LockID("d.bin", "", true) {
+		t.Fatalf("expected a conflict with the lock on all refs, got %v", err)
+	}
+}
+
+func TestFileLockStore(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"locks", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testLockStore(t, NewFileLockStore(dir))
+}
+
+func TestSQLiteLockStore(t *testing.T) {
+	store, err := OpenSQLiteLockStore(filepath.Join(t.TempDir(), "locks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testLockStore(t, store)
+}
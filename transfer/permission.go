@@ -0,0 +1,35 @@
+package transfer
+
+// Permission is a set of rights on a repository, granted to a session.
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermLock
+	PermAdmin
+
+	PermAll = PermRead | PermWrite | PermLock | PermAdmin
+	// PermDefault is granted when there is no access policy. Forcing unlocks
+	// needs PermAdmin, which has to be granted explicitly.
+	PermDefault = PermRead | PermWrite | PermLock
+)
+
+// requiredPermission returns the permission needed to run verb in a session
+// for the given operation.
+func requiredPermission(verb string, operation string) Permission {
+	switch verb {
+	case "batch":
+		if operation == "upload" {
+			return PermWrite
+		}
+		return PermRead
+	case "get-object", "list-lock", "list-locks":
+		return PermRead
+	case "put-object", "verify-object":
+		return PermWrite
+	case "lock", "unlock":
+		return PermLock
+	}
+	return 0
+}
@@ -1,4 +1,4 @@
-package internal
+package transfer
 
 import (
 	"fmt"
@@ -10,12 +10,10 @@ import (
 )
 
 type PktlineChannel struct {
-	mu   sync.Mutex
-	pl   *pktline.Pktline
-	req  *ChannelRequest
-	fs   *Filesystem
-	path string
-	err  error
+	mu  sync.Mutex
+	pl  *pktline.Pktline
+	req *ChannelRequest
+	err error
 }
 
 type ChannelRequest struct {
@@ -25,14 +23,26 @@ type ChannelRequest struct {
 	err   error
 }
 
-func NewPktlineChannel(r io.Reader, w io.Writer, p string) *PktlineChannel {
-	pc := &PktlineChannel{
-		pl:   pktline.NewPktline(r, w),
-		path: p,
+// Args returns the argument lines of the request, the first one being the
+// command.
+func (r *ChannelRequest) Args() []string {
+	return r.args
+}
+
+// Lines returns the lines following the delimiter packet.
+func (r *ChannelRequest) Lines() []string {
+	return r.lines
+}
+
+// Data returns the object data sent with put-object, or nil.
+func (r *ChannelRequest) Data() io.Reader {
+	return r.data
+}
+
+func NewPktlineChannel(r io.Reader, w io.Writer) *PktlineChannel {
+	return &PktlineChannel{
+		pl: pktline.NewPktline(r, w),
 	}
-	fs := &Filesystem{pc}
-	pc.fs = fs
-	return pc
 }
 
 func (pc *PktlineChannel) Lock() {
@@ -53,6 +63,20 @@ func (pc *PktlineChannel) Start() error {
 	return nil
 }
 
+// Refuse advertises the capabilities like Start, then answers the first
+// request with status and message. It is used for sessions that cannot be
+// served at all, so that clients see the refusal as the response to their
+// first command.
+func (pc *PktlineChannel) Refuse(status string, message string) error {
+	if err := pc.Start(); err != nil {
+		return err
+	}
+	if !pc.Scan() || pc.req.err == io.EOF {
+		return nil
+	}
+	return pc.SendMessage([]string{status}, []string{message})
+}
+
 func (pc *PktlineChannel) End() error {
 	pc.Lock()
 	defer pc.Unlock()
@@ -138,7 +162,8 @@ func (pc *PktlineChannel) ReadMessage() ([]string, []string, io.Reader, error) {
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		if strings.HasPrefix(s, "put-object") {
+		// only put-object requests carry data after the delimiter
+		if len(args) == 0 && (s == "put-object" || strings.HasPrefix(s, "put-object ")) {
 			data = true
 		}
 		if data {
@@ -153,13 +178,13 @@ func (pc *PktlineChannel) ReadMessage() ([]string, []string, io.Reader, error) {
 			switch {
 			case pktLen == 0:
 				return args, lines, nil, nil
-			case delim:
-				lines = append(lines, s)
 			case pktLen == 1:
 				if delim {
 					return nil, nil, nil, fmt.Errorf("unexpected delimiter packet")
 				}
 				delim = true
+			case delim:
+				lines = append(lines, s)
 			default:
 				args = append(args, s)
 			}
@@ -171,6 +196,27 @@ func (pc *PktlineChannel) ReadMessage() ([]string, []string, io.Reader, error) {
 	return args, lines, nil, nil
 }
 
+// ReadMessageData reads a message whose arguments may be followed by a
+// delimiter and data, such as a get-object response. The returned reader
+// yields the data up to the next flush packet, or is nil if there is none.
+func (pc *PktlineChannel) ReadMessageData() ([]string, io.Reader, error) {
+	args := make([]string, 0, 10)
+	for {
+		s, pktLen, err := pc.pl.ReadPacketTextWithLength()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch pktLen {
+		case 0:
+			return args, nil, nil
+		case 1:
+			return args, pktline.NewPktlineReaderFromPktline(pc.pl, 65536), nil
+		default:
+			args = append(args, s)
+		}
+	}
+}
+
 func (pc *PktlineChannel) readRequest() (*ChannelRequest, error) {
 	args, lines, data, err := pc.ReadMessage()
 	req := &ChannelRequest{
@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func FuzzReadMessage(f *testing.F) {
+	oid := "6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a84118090"
+	for _, seed := range []string{
+		"000eversion 1\n0000",
+		pkt("batch\n") + pkt("hash-algo=sha256\n") + "0001" + pkt(oid+" 6\n") + "0000",
+		pkt("put-object "+oid+"\n") + pkt("size=6\n") + "0001" + pkt("abc123") + "0000",
+		pkt("put-object "+oid+"\n") + "0000",
+		pkt("lock\n") + "0001" + pkt("put-object\n") + "0001" + "0001" + "0000",
+		"0003",
+		"zzzz",
+		"0010short",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		c := NewPktlineChannel(bytes.NewReader(b), io.Discard)
+		// every message reads at least one packet of four bytes
+		for i := 0; i <= len(b)/4; i++ {
+			args, _, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if data != nil {
+				if len(args) == 0 || !strings.HasPrefix(args[0], "put-object") {
+					t.Fatalf("data for request %q", args)
+				}
+				io.Copy(io.Discard, data)
+			}
+		}
+	})
+}
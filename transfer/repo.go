@@ -0,0 +1,124 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRepoNotFound is returned for paths that are not a Git repository.
+var ErrRepoNotFound = errors.New("repository not found")
+
+// Repository is a Git repository served by a Server.
+type Repository struct {
+	GitDir    string
+	CommonDir string
+	Bare      bool
+	LFSDir    string
+	config    *gitConfig
+}
+
+// OpenRepository opens the repository at path, a Git directory or a working
+// tree. Unlike Git, it does not look at GIT_DIR: callers that want it honoured
+// pass it as path.
+func OpenRepository(path string) (*Repository, error) {
+	gitDir, err := resolveGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	commonDir := gitDir
+	if b, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(b))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+
+	cfg, err := readGitConfig(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{
+		GitDir:    filepath.Clean(gitDir),
+		CommonDir: filepath.Clean(commonDir),
+		config:    cfg,
+	}
+	repo.Bare = cfg.Bool("core.bare", filepath.Base(repo.CommonDir) != ".git")
+
+	repo.LFSDir = filepath.Join(repo.CommonDir, "lfs")
+	if storage, ok := cfg.Get("lfs.storage"); ok && storage != "" {
+		if !filepath.IsAbs(storage) {
+			storage = filepath.Join(repo.CommonDir, storage)
+		}
+		repo.LFSDir = filepath.Clean(storage)
+	}
+	return repo, nil
+}
+
+// Config returns the last value of key in the configuration of the
+// repository.
+func (r *Repository) Config(key string) (string, bool) {
+	return r.config.Get(key)
+}
+
+// ConfigBool returns the boolean value of key, or def if it is not set.
+func (r *Repository) ConfigBool(key string, def bool) bool {
+	return r.config.Bool(key, def)
+}
+
+func resolveGitDir(path string) (string, error) {
+	if isGitDir(path) {
+		return path, nil
+	}
+
+	dotGit := filepath.Join(path, ".git")
+	fi, err := os.Stat(dotGit)
+	if err != nil {
+		return "", ErrRepoNotFound
+	}
+	if fi.IsDir() {
+		if !isGitDir(dotGit) {
+			return "", ErrRepoNotFound
+		}
+		return dotGit, nil
+	}
+	return readGitFile(dotGit)
+}
+
+// readGitFile resolves a `.git` file as written for worktrees and submodules.
+func readGitFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", ErrRepoNotFound
+	}
+	dir := strings.TrimSpace(line[len("gitdir:"):])
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	if !isGitDir(dir) {
+		return "", ErrRepoNotFound
+	}
+	return dir, nil
+}
+
+func isGitDir(path string) bool {
+	if fi, err := os.Stat(filepath.Join(path, "HEAD")); err != nil || fi.IsDir() {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(path, "commondir")); err == nil {
+		return true
+	}
+	for _, dir := range []string{"objects", "refs"} {
+		if fi, err := os.Stat(filepath.Join(path, dir)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func initGitDir(t *testing.T, dir string, config string) {
+	t.Helper()
+	for _, d := range []string{"objects", "refs"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenRepository(t *testing.T) {
+	root := t.TempDir()
+
+	bare := filepath.Join(root, "bare.git")
+	initGitDir(t, bare, "[core]\n\tbare = true\n")
+
+	nonBare := filepath.Join(root, "work")
+	initGitDir(t, filepath.Join(nonBare, ".git"), "[core]\n\tbare = false\n")
+
+	storage := filepath.Join(root, "storage.git")
+	initGitDir(t, storage, "[core]\n\tbare = true\n[lfs]\n\tstorage = \"../shared lfs\" ; comment\n")
+
+	worktree := filepath.Join(root, "worktree")
+	wtGitDir := filepath.Join(nonBare, ".git", "worktrees", "wt")
+	if err := os.MkdirAll(wtGitDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(wtGitDir, "HEAD"), []byte("ref: refs/heads/wt\n"), 0644)
+	os.WriteFile(filepath.Join(wtGitDir, "commondir"), []byte("../..\n"), 0644)
+	os.MkdirAll(worktree, os.ModePerm)
+	os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: ../work/.git/worktrees/wt\n"), 0644)
+
+	tests := []struct {
+		path   string
+		gitDir string
+		bare   bool
+		lfsDir string
+	}{
+		{bare, bare, true, filepath.Join(bare, "lfs")},
+		{nonBare, filepath.Join(nonBare, ".git"), false, filepath.Join(nonBare, ".git", "lfs")},
+		{filepath.Join(nonBare, ".git"), filepath.Join(nonBare, ".git"), false, filepath.Join(nonBare, ".git", "lfs")},
+		{storage, storage, true, filepath.Join(root, "shared lfs")},
+		{worktree, wtGitDir, false, filepath.Join(nonBare, ".git", "lfs")},
+	}
+	for _, tt := range tests {
+		repo, err := OpenRepository(tt.path)
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if repo.GitDir != tt.gitDir || repo.Bare != tt.bare || repo.LFSDir != tt.lfsDir {
+			t.Errorf("%s: got (%s, %v, %s), want (%s, %v, %s)", tt.path,
+				repo.GitDir, repo.Bare, repo.LFSDir, tt.gitDir, tt.bare, tt.lfsDir)
+		}
+	}
+
+	t.Setenv("GIT_DIR", bare)
+	repo, err := OpenRepository(nonBare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(nonBare, ".git"); repo.GitDir != want {
+		t.Errorf("GIT_DIR was honoured: got %s, want %s", repo.GitDir, want)
+	}
+}
@@ -0,0 +1,141 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrReadOnly is returned by Serve for upload sessions of a read-only
+// server.
+var ErrReadOnly = errors.New("repository is read-only")
+
+// ErrNoIdentity is returned by Serve for a server without an Identity.
+var ErrNoIdentity = errors.New("no identity for the session")
+
+// Server serves the Git LFS SSH transfer protocol for a repository on behalf
+// of a user. Objects are kept in Storage and locks in Locks, while partial
+// uploads, temporary files and logs go to the lfs directory of Repo.
+type Server struct {
+	Repo     *Repository
+	Storage  Storage
+	Locks    LockStore
+	Identity *Identity
+	// Permissions are the commands the user may run, see Permission.
+	Permissions Permission
+	// ReadOnly refuses everything that would modify the repository.
+	ReadOnly bool
+	// RefLocks scopes locks taken with a refname to that ref.
+	RefLocks bool
+	// LockTTL is how long new locks last, or zero for locks that never
+	// expire.
+	LockTTL time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+
+	handlers map[string]Handler
+}
+
+// NewServer returns a server granting id PermDefault on repo. It panics if id
+// is nil.
+func NewServer(repo *Repository, store Storage, locks LockStore, id *Identity) *Server {
+	if id == nil {
+		panic("transfer: NewServer with nil identity")
+	}
+	return &Server{
+		Repo:        repo,
+		Storage:     store,
+		Locks:       locks,
+		Identity:    id,
+		Permissions: PermDefault,
+	}
+}
+
+// Serve runs a session for operation, "upload" or "download", reading
+// requests from rw and writing the responses to it until the client quits.
+func (s *Server) Serve(rw io.ReadWriter, operation string) error {
+	c := NewPktlineChannel(rw, rw)
+	if s.Identity == nil {
+		c.Refuse("status 500", ErrNoIdentity.Error())
+		return ErrNoIdentity
+	}
+	if s.ReadOnly && operation == "upload" {
+		c.Refuse("status 403", ErrReadOnly.Error())
+		return ErrReadOnly
+	}
+
+	lfsPath := s.Repo.LFSDir
+	for _, dir := range []string{"incomplete", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(lfsPath, dir), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	fs := &filesystem{
+		c:        c,
+		path:     lfsPath,
+		store:    s.Storage,
+		locks:    s.Locks,
+		id:       s.Identity,
+		perms:    s.Permissions,
+		refLocks: s.RefLocks,
+		lockTTL:  s.LockTTL,
+		now:      s.Now,
+	}
+	if fs.now == nil {
+		fs.now = time.Now
+	}
+	sess := &Session{Server: s, Channel: c, Operation: operation, fs: fs}
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+	for c.Scan() {
+		if c.req.err == io.EOF {
+			// nothing to read - EOF channel
+			break
+		}
+		if c.req.err != nil {
+			return c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("%s", c.req.err)})
+		}
+		if len(c.req.args) == 0 {
+			// nothing to read, args empty
+			continue
+		}
+		verb := strings.SplitN(c.req.args[0], " ", 2)[0]
+		if need := requiredPermission(verb, operation); s.Permissions&need != need {
+			return c.SendMessage([]string{"status 403"}, []string{"permission denied"})
+		}
+		if s.ReadOnly && requiredPermission(verb, operation)&(PermWrite|PermLock) != 0 {
+			return c.SendMessage([]string{"status 403"}, []string{ErrReadOnly.Error()})
+		}
+		if len(c.req.args) > 2 {
+			if strings.HasPrefix(c.req.args[2], "hash-algo=") {
+				if c.req.args[2] != "hash-algo=sha256" {
+					return c.SendMessage([]string{"status 400"}, []string{"unsupported hash algorithm"})
+				}
+			}
+		}
+		h := s.handler(verb)
+		if h == nil {
+			err = c.SendMessage([]string{"status 400"}, []string{fmt.Sprintf("unknown command %q", verb)})
+		} else {
+			err = h(sess, c.req)
+		}
+		if c.req.data != nil {
+			// data a handler did not read would be taken for the next request
+			io.Copy(io.Discard, c.req.data)
+		}
+		if err == ErrEndSession {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pkt(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "repo.git")
+	initGitDir(t, dir, "[core]\n\tbare = true\n")
+	repo, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"objects", "tmp", "locks"} {
+		if err := os.MkdirAll(filepath.Join(repo.LFSDir, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServer(repo, NewLocalStorage(repo.LFSDir), NewFileLockStore(repo.LFSDir), &Identity{User: "alice"})
+}
+
+func serve(s *Server, operation string, input string) (string, error) {
+	out := new(bytes.Buffer)
+	err := s.Serve(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), out}, operation)
+	return out.String(), err
+}
+
+func TestServer(t *testing.T) {
+	s := newTestServer(t)
+	oid := "6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a84118090"
+
+	input := "000eversion 1\n0000" +
+		pkt("put-object "+oid+"\n") + pkt("size=6\n") + "0001" + pkt("abc123") + "0000" +
+		pkt("lock\n") + pkt("path=test.zip\n") + "0000" +
+		pkt("quit\n") + "0000"
+	result, err := serve(s, "upload", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"0000000fstatus 200\n0000", "000fstatus 201\n", pkt("ownername=alice\n"), "000fstatus 200\n0000"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %q in\n%s", expected, result)
+		}
+	}
+	if size, err := s.Storage.Stat(oid); err != nil || size != 6 {
+		t.Errorf("object not stored: %d, %v", size, err)
+	}
+
+	s.Identity = &Identity{User: "bob"}
+	s.Permissions = PermRead
+	result, _ = serve(s, "download", "000eversion 1\n0000"+pkt("get-object "+oid+"\n")+"0000"+pkt("list-lock\n")+"0000")
+	for _, expected := range []string{pkt("size=6\n") + "0001" + pkt("abc123"), "owner c7b8de23fd238fe5e16f6f03b844022f9f72fd168a0704d82d58f19cf72b7aa3 theirs\n"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %q in\n%s", expected, result)
+		}
+	}
+
+	result, _ = serve(s, "download", "000eversion 1\n0000"+pkt("lock\n")+pkt("path=other.zip\n")+"0000")
+	if !strings.HasSuffix(result, "000fstatus 403\n0001"+pkt("permission denied\n")+"0000") {
+		t.Errorf("lock without permission was not refused:\n%s", result)
+	}
+
+	s.ReadOnly = true
+	result, err = serve(s, "upload", "000eversion 1\n0000")
+	if err != ErrReadOnly || result != "000eversion=1\n000clocking\n0000000fstatus 403\n0001"+pkt("repository is read-only\n")+"0000" {
+		t.Errorf("upload to read-only server was not refused: %v\n%s", err, result)
+	}
+}
+
+func TestServerHandlers(t *testing.T) {
+	s := newTestServer(t)
+
+	result, err := serve(s, "upload", "000eversion 1\n0000"+pkt("locks\n")+pkt("path=test.zip\n")+"0000"+pkt("quit\n")+"0000")
+	if err != nil || !strings.Contains(result, "000fstatus 400\n0001"+pkt("unknown command \"locks\"\n")+"0000000fstatus 200\n0000") {
+		t.Errorf("unknown command was not refused: %v\n%s", err, result)
+	}
+	if _, err := s.Locks.Get(LockID("test.zip", "", false)); err == nil {
+		t.Errorf("locks was run as lock")
+	}
+
+	s.Handle("ping", func(sess *Session, req *ChannelRequest) error {
+		return sess.Channel.SendMessage([]string{"status 200"}, []string{"pong " + sess.Server.Identity.User})
+	})
+	s.Handle("version", func(sess *Session, req *ChannelRequest) error {
+		return sess.Channel.SendMessage([]string{"status 200"}, []string{req.Args()[0]})
+	})
+	result, err = serve(s, "download", pkt("version 2\n")+"0000"+pkt("ping\n")+"0000")
+	if err != nil || !strings.HasSuffix(result, "000fstatus 200\n0001"+pkt("version 2\n")+"0000000fstatus 200\n0001"+pkt("pong alice\n")+"0000") {
+		t.Errorf("registered handlers were not run: %v\n%s", err, result)
+	}
+}
+
+func TestServerNoIdentity(t *testing.T) {
+	s := newTestServer(t)
+	s.Identity = nil
+	result, err := serve(s, "download", "000eversion 1\n0000"+pkt("list-lock\n")+"0000")
+	if err != ErrNoIdentity {
+		t.Errorf("got error %v, want %v", err, ErrNoIdentity)
+	}
+	if !strings.HasSuffix(result, "000fstatus 500\n0001"+pkt("no identity for the session\n")+"0000") {
+		t.Errorf("session was not refused:\n%s", result)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewServer accepted a nil identity")
+		}
+	}()
+	NewServer(s.Repo, s.Storage, s.Locks, nil)
+}
+
+// vanishingLockStore reports a conflict with a lock that is removed right
+// away, on the first Create.
+type vanishingLockStore struct {
+	LockStore
+	removed bool
+}
+
+func (s *vanishingLockStore) Create(lock *Lock) error {
+	if !s.removed {
+		s.removed = true
+		return &LockConflictError{Lock: &Lock{ID: lock.ID, Path: lock.Path, Owner: "bob", LockedAt: "2023-01-02T03:04:05Z"}}
+	}
+	return s.LockStore.Create(lock)
+}
+
+func TestServerLockRemovedConflict(t *testing.T) {
+	s := newTestServer(t)
+	s.Locks = &vanishingLockStore{LockStore: s.Locks}
+
+	result, err := serve(s, "upload", "000eversion 1\n0000"+pkt("lock\n")+pkt("path=test.zip\n")+"0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result, "000fstatus 201\n") || !strings.Contains(result, pkt("ownername=alice\n")) {
+		t.Errorf("lock was not created once the conflict was gone:\n%s", result)
+	}
+}
+
+func TestServerLockLogFailure(t *testing.T) {
+	s := newTestServer(t)
+	// the log directory cannot be created
+	if err := os.WriteFile(filepath.Join(s.Repo.LFSDir, "logs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	refused := "000fstatus 500\n0001" + pkt("cannot write lock log\n") + "0000"
+
+	id := LockID("test.zip", "", false)
+	result, _ := serve(s, "upload", "000eversion 1\n0000"+pkt("lock\n")+pkt("path=test.zip\n")+"0000")
+	if !strings.HasSuffix(result, refused) {
+		t.Errorf("lock was not refused:\n%s", result)
+	}
+	if _, err := s.Locks.Get(id); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock was created: %v", err)
+	}
+
+	lock := &Lock{ID: id, Path: "test.zip", Owner: "alice", LockedAt: "2023-01-02T03:04:05Z"}
+	if err := s.Locks.Create(lock); err != nil {
+		t.Fatal(err)
+	}
+	s.Permissions = PermAll
+	result, _ = serve(s, "upload", "000eversion 1\n0000"+pkt("unlock "+id+"\n")+pkt("force=true\n")+"0000")
+	if !strings.HasSuffix(result, refused) {
+		t.Errorf("unlock was not refused:\n%s", result)
+	}
+	if _, err := s.Locks.Get(id); err != nil {
+		t.Errorf("lock was removed: %v", err)
+	}
+}
+
+func TestServerLockLogTime(t *testing.T) {
+	s := newTestServer(t)
+	s.Now = func() time.Time { return time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	if _, err := serve(s, "upload", "000eversion 1\n0000"+pkt("lock\n")+pkt("path=test.zip\n")+"0000"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(s.Repo.LFSDir, "logs", "locks.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"time":"2023-01-02T03:04:05Z"`) {
+		t.Errorf("lock not logged at the session time: %s", b)
+	}
+}
@@ -0,0 +1,178 @@
+package transfer
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteLockSchema = `
+CREATE TABLE IF NOT EXISTS locks (
+	id TEXT PRIMARY KEY,
+	path TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	locked_at TEXT NOT NULL,
+	refname TEXT NOT NULL DEFAULT '',
+	expires_at TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS locks_path ON locks (path);
+CREATE INDEX IF NOT EXISTS locks_owner ON locks (owner);
+CREATE INDEX IF NOT EXISTS locks_refname ON locks (refname);
+`
+
+// SQLiteLockStore keeps locks in an SQLite database, which unlike the file
+// store can look up locks by path, owner and ref without reading all of them.
+type SQLiteLockStore struct {
+	db *sql.DB
+}
+
+func OpenSQLiteLockStore(path string) (*SQLiteLockStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// several sessions may use the database at once, writers wait for each
+	// other and take the write lock when a transaction begins
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteLockSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create lock database: %s", err)
+	}
+	return &SQLiteLockStore{db: db}, nil
+}
+
+const sqliteLockColumns = "id, path, owner, locked_at, refname, expires_at"
+
+type sqliteScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLock(row sqliteScanner) (*Lock, error) {
+	lock := &Lock{Version: lockFormatVersion}
+	err := row.Scan(&lock.ID, &lock.Path, &lock.Owner, &lock.LockedAt, &lock.Refname, &lock.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	return lock, err
+}
+
+func (s *SQLiteLockStore) Create(lock *Lock) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT "+sqliteLockColumns+" FROM locks WHERE id = ? OR path = ? ORDER BY id", lock.ID, lock.Path)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		existing, err := scanLock(rows)
+		if err != nil {
+			return err
+		}
+		if lock.Conflicts(existing) {
+			return &LockConflictError{Lock: existing}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec("INSERT INTO locks ("+sqliteLockColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		lock.ID, lock.Path, lock.Owner, lock.LockedAt, lock.Refname, lock.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteLockStore) Get(id string) (*Lock, error) {
+	lock, err := scanLock(s.db.QueryRow("SELECT "+sqliteLockColumns+" FROM locks WHERE id = ?", id))
+	if err == os.ErrNotExist {
+		return nil, fmt.Errorf("lock %s: %w", id, os.ErrNotExist)
+	}
+	return lock, err
+}
+
+func (s *SQLiteLockStore) Delete(id string, cond func(lock *Lock) bool) (*Lock, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lock, err := scanLock(tx.QueryRow("SELECT "+sqliteLockColumns+" FROM locks WHERE id = ?", id))
+	if err == os.ErrNotExist {
+		return nil, fmt.Errorf("lock %s: %w", id, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cond != nil && !cond(lock) {
+		return nil, nil
+	}
+	if _, err := tx.Exec("DELETE FROM locks WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return lock, tx.Commit()
+}
+
+func (s *SQLiteLockStore) List(filter LockFilter, fn func(lock *Lock) error) error {
+	var where []string
+	var args []any
+	for _, f := range []struct {
+		column string
+		value  string
+	}{
+		{"id = ?", filter.ID},
+		{"path = ?", filter.Path},
+		{"owner = ?", filter.Owner},
+		{"refname IN (?, '')", filter.Refname},
+		{"id >= ?", filter.Cursor},
+	} {
+		if f.value != "" {
+			where = append(where, f.column)
+			args = append(args, f.value)
+		}
+	}
+	query := "SELECT " + sqliteLockColumns + " FROM locks"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		lock, err := scanLock(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(lock); err != nil {
+			if err == ErrStopLocks {
+				return nil
+			}
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteLockStore) Close() error {
+	return s.db.Close()
+}
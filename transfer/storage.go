@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Storage holds the LFS objects of a repository. Methods report missing
+// objects with errors matching os.ErrNotExist. Open returns the content of
+// an object starting at offset.
+type Storage interface {
+	Stat(oid string) (int64, error)
+	Open(oid string, offset int64) (io.ReadCloser, error)
+	Create(oid string) (ObjectWriter, error)
+	Delete(oid string) error
+	List(fn func(oid string, size int64) error) error
+}
+
+// ObjectWriter receives the content of a new object, which only becomes
+// visible once committed.
+type ObjectWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// objectImporter is implemented by stores that can take over a complete
+// object file without copying it.
+type objectImporter interface {
+	Import(oid string, path string) error
+}
+
+// importObject moves the verified object at path into store.
+func importObject(store Storage, oid string, path string) error {
+	if imp, ok := store.(objectImporter); ok {
+		return imp.Import(oid, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := store.Create(oid)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Commit(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// LocalStorage keeps objects in the layout used by Git LFS,
+// objects/aa/bb/aabb..., staging new ones in tmp.
+type LocalStorage struct {
+	dir string
+	tmp string
+}
+
+func NewLocalStorage(lfsPath string) *LocalStorage {
+	return &LocalStorage{
+		dir: filepath.Join(lfsPath, "objects"),
+		tmp: filepath.Join(lfsPath, "tmp"),
+	}
+}
+
+func (s *LocalStorage) path(oid string) string {
+	return filepath.Join(s.dir, oid[0:2], oid[2:4], oid)
+}
+
+func (s *LocalStorage) Stat(oid string) (int64, error) {
+	fi, err := os.Stat(s.path(oid))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *LocalStorage) Open(oid string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(oid))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStorage) Create(oid string) (ObjectWriter, error) {
+	f, err := os.CreateTemp(s.tmp, "dst")
+	if err != nil {
+		return nil, err
+	}
+	return &localObjectWriter{File: f, s: s, oid: oid}, nil
+}
+
+func (s *LocalStorage) Import(oid string, path string) error {
+	dst := s.path(oid)
+	err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path, dst)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0775)
+}
+
+func (s *LocalStorage) Delete(oid string) error {
+	return os.Remove(s.path(oid))
+}
+
+func (s *LocalStorage) List(fn func(oid string, size int64) error) error {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(d.Name(), fi.Size())
+	})
+}
+
+type localObjectWriter struct {
+	*os.File
+	s   *LocalStorage
+	oid string
+}
+
+func (w *localObjectWriter) Commit() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return w.s.Import(w.oid, w.File.Name())
+}
+
+func (w *localObjectWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}